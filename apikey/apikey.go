@@ -0,0 +1,120 @@
+package apikey
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+	"tzcnlr/user"
+)
+
+var ErrInvalidAPIKey = errors.New("invalid api key")
+
+// Scopes are the /api resources a key can be limited to.
+var Scopes = []string{"completedTasks", "companies", "branches", "machines"}
+
+const keyPrefix = "tzk_"
+
+type APIKey struct {
+	APIKeyID   int        `json:"id"`
+	Name       string     `json:"name"`
+	KeyPrefix  string     `json:"keyPrefix"`
+	Role       string     `json:"role"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+	RevokedAt  *time.Time `json:"revokedAt"`
+	// Key is only set in the response creating the key, it is never stored
+	Key string `json:"key,omitempty"`
+}
+
+func (k *APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+type APIKeyService struct {
+	kDB *APIKeyDB
+}
+
+func NewAPIKeyService(kDB *APIKeyDB) *APIKeyService {
+	return &APIKeyService{
+		kDB: kDB,
+	}
+}
+
+// PutAPIKey creates a new key and returns it including the plaintext key.
+func (s *APIKeyService) PutAPIKey(apiKey APIKey) (APIKey, error) {
+	if err := validateAPIKey(apiKey); err != nil {
+		return APIKey{}, err
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return APIKey{}, err
+	}
+	apiKey.Key = keyPrefix + base64.RawURLEncoding.EncodeToString(secret)
+	apiKey.KeyPrefix = apiKey.Key[:len(keyPrefix)+8]
+
+	created, err := s.kDB.PutAPIKey(apiKey, hashKey(apiKey.Key))
+	if err != nil {
+		return APIKey{}, err
+	}
+	created.Key = apiKey.Key
+	return created, nil
+}
+
+func (s *APIKeyService) RevokeAPIKeyByName(name string) error {
+	err := s.kDB.RevokeAPIKeyByName(name)
+	return err
+}
+
+func (s *APIKeyService) GetAPIKeys() ([]APIKey, error) {
+	result, err := s.kDB.GetAPIKeys()
+	return result, err
+}
+
+// Authenticate returns the active key matching the plaintext key and marks it as used.
+func (s *APIKeyService) Authenticate(key string) (APIKey, error) {
+	result, err := s.kDB.UseAPIKey(hashKey(key))
+	return result, err
+}
+
+func validateAPIKey(apiKey APIKey) error {
+	if apiKey.Name == "" {
+		return errors.New("api key name not set")
+	}
+	if apiKey.Role != user.RoleDispatcher && apiKey.Role != user.RoleViewer {
+		return fmt.Errorf("api key role must be %q or %q", user.RoleDispatcher, user.RoleViewer)
+	}
+	if len(apiKey.Scopes) == 0 {
+		return errors.New("api key scopes not set")
+	}
+	for _, scope := range apiKey.Scopes {
+		if !isKnownScope(scope) {
+			return fmt.Errorf("unknown scope %q", scope)
+		}
+	}
+	return nil
+}
+
+func isKnownScope(scope string) bool {
+	for _, s := range Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
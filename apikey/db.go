@@ -0,0 +1,96 @@
+package apikey
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type APIKeyDB struct {
+	db *pgxpool.Pool
+}
+
+func NewAPIKeyDB(db *pgxpool.Pool) *APIKeyDB {
+	return &APIKeyDB{
+		db: db,
+	}
+}
+
+const apiKeyColumns = "api_key_id, api_key_name, key_prefix, role, scopes, created_at, last_used_at, revoked_at"
+
+func scanAPIKey(row pgx.Row) (APIKey, error) {
+	var apiKey APIKey
+	err := row.Scan(
+		&apiKey.APIKeyID,
+		&apiKey.Name,
+		&apiKey.KeyPrefix,
+		&apiKey.Role,
+		&apiKey.Scopes,
+		&apiKey.CreatedAt,
+		&apiKey.LastUsedAt,
+		&apiKey.RevokedAt)
+	return apiKey, err
+}
+
+func (c *APIKeyDB) PutAPIKey(apiKey APIKey, keyHash string) (APIKey, error) {
+	query := `
+		INSERT INTO api_keys (api_key_name, key_prefix, key_hash, role, scopes)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING ` + apiKeyColumns
+
+	row := c.db.QueryRow(context.Background(), query, apiKey.Name, apiKey.KeyPrefix, keyHash, apiKey.Role, apiKey.Scopes)
+	return scanAPIKey(row)
+}
+
+func (c *APIKeyDB) RevokeAPIKeyByName(name string) error {
+	sql := `UPDATE api_keys SET revoked_at = now() WHERE api_key_name = $1 AND revoked_at IS NULL`
+
+	res, err := c.db.Exec(context.Background(), sql, name)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return errors.New("no active api key with the specified name")
+	}
+	return nil
+}
+
+func (c *APIKeyDB) GetAPIKeys() ([]APIKey, error) {
+	query := "SELECT " + apiKeyColumns + " FROM api_keys ORDER BY api_key_id"
+
+	var apiKeys []APIKey
+	rows, err := c.db.Query(context.Background(), query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+	for rows.Next() {
+		apiKey, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		apiKeys = append(apiKeys, apiKey)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return apiKeys, nil
+}
+
+// UseAPIKey looks up an active key by hash and stamps its last use in the same statement.
+func (c *APIKeyDB) UseAPIKey(keyHash string) (APIKey, error) {
+	query := `
+		UPDATE api_keys SET last_used_at = now()
+		WHERE key_hash = $1 AND revoked_at IS NULL
+		RETURNING ` + apiKeyColumns
+
+	apiKey, err := scanAPIKey(c.db.QueryRow(context.Background(), query, keyHash))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return apiKey, ErrInvalidAPIKey
+	}
+	return apiKey, err
+}
@@ -0,0 +1,96 @@
+package apikey
+
+import (
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"net/http"
+)
+
+type APIKeyAPI struct {
+	s *APIKeyService
+}
+
+func NewAPIKeyAPI(s *APIKeyService) *APIKeyAPI {
+	return &APIKeyAPI{
+		s: s,
+	}
+}
+
+func (api *APIKeyAPI) HandlePostAPIKey(w http.ResponseWriter, r *http.Request) {
+	body, ok := r.Context().Value("body").([]byte)
+	if !ok {
+		http.Error(w, "error accessing the body of the request", http.StatusInternalServerError)
+		return
+	}
+
+	if len(body) == 0 {
+		http.Error(w, "empty request body", http.StatusBadRequest)
+		return
+	}
+
+	var apiKey APIKey
+	if err := json.Unmarshal(body, &apiKey); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := validateAPIKey(apiKey); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := api.s.PutAPIKey(apiKey)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	jsonResponse, err := json.Marshal(result)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(jsonResponse)
+}
+
+func (api *APIKeyAPI) HandleRevokeAPIKeyByName(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	name := vars["apiKeyName"]
+
+	if name == "" {
+		http.Error(w, "api key name not set", http.StatusBadRequest)
+		return
+	}
+
+	err := api.s.RevokeAPIKeyByName(name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (api *APIKeyAPI) HandleGetAPIKeys(w http.ResponseWriter, r *http.Request) {
+	result, err := api.s.GetAPIKeys()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// dont return null
+	if result == nil {
+		result = []APIKey{}
+	}
+
+	jsonResponse, err := json.Marshal(result)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
@@ -0,0 +1,107 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"time"
+	"tzcnlr/auth"
+)
+
+const (
+	ActionCreate  = "create"
+	ActionUpdate  = "update"
+	ActionDelete  = "delete"
+	ActionArchive = "archive"
+	ActionRestore = "restore"
+	ActionImport  = "import"
+)
+
+const (
+	EntityCompany       = "company"
+	EntityBranch        = "branch"
+	EntityMachine       = "machine"
+	EntityCompletedTask = "completedTask"
+)
+
+// Entry records who changed which entity and how, Before is empty for created entities and
+// After for deleted ones.
+type Entry struct {
+	AuditID    int             `json:"id"`
+	Actor      string          `json:"actor"`
+	Action     string          `json:"action"`
+	EntityType string          `json:"entityType"`
+	EntityID   string          `json:"entityId"`
+	Before     json.RawMessage `json:"before,omitempty"`
+	After      json.RawMessage `json:"after,omitempty"`
+	CreatedAt  time.Time       `json:"createdAt"`
+}
+
+// Filter narrows the audit log, zero values do not filter. BeforeID continues a listing
+// after the last entry of the previous page.
+type Filter struct {
+	Actor      string
+	Action     string
+	EntityType string
+	EntityID   string
+	From       time.Time
+	To         time.Time
+	BeforeID   int
+	Limit      int
+}
+
+type AuditService struct {
+	aDB *AuditDB
+}
+
+func NewAuditService(aDB *AuditDB) *AuditService {
+	return &AuditService{
+		aDB: aDB,
+	}
+}
+
+// Recorder stores the entry of a change in the transaction of the change, with the states
+// read in that transaction. An error rolls the change back, so no change goes unrecorded.
+type Recorder func(tx pgx.Tx, entityID string, before, after interface{}) error
+
+// Recorder returns the Recorder of action on entityType for the caller of ctx.
+func (s *AuditService) Recorder(ctx context.Context, action, entityType string) Recorder {
+	actor := auth.Actor(ctx)
+	return func(tx pgx.Tx, entityID string, before, after interface{}) error {
+		entry := Entry{
+			Actor:      actor,
+			Action:     action,
+			EntityType: entityType,
+			EntityID:   entityID,
+		}
+
+		var err error
+		if entry.Before, err = marshalState(before); err != nil {
+			return fmt.Errorf("error recording audit entry: %w", err)
+		}
+		if entry.After, err = marshalState(after); err != nil {
+			return fmt.Errorf("error recording audit entry: %w", err)
+		}
+		if err = putEntry(tx, entry); err != nil {
+			return fmt.Errorf("error recording audit entry: %w", err)
+		}
+		return nil
+	}
+}
+
+func (s *AuditService) GetEntries(filter Filter) ([]Entry, error) {
+	return s.aDB.GetEntries(filter)
+}
+
+// marshalState encodes an entity state, nil and nil pointers are stored as no state at all.
+func marshalState(state interface{}) (json.RawMessage, error) {
+	if state == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(state)
+	if err != nil || string(data) == "null" {
+		return nil, err
+	}
+	return data, nil
+}
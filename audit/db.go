@@ -0,0 +1,94 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type AuditDB struct {
+	db *pgxpool.Pool
+}
+
+func NewAuditDB(db *pgxpool.Pool) *AuditDB {
+	return &AuditDB{
+		db: db,
+	}
+}
+
+func putEntry(tx pgx.Tx, entry Entry) error {
+	query := `
+		INSERT INTO audit_log (actor, action, entity_type, entity_id, before_state, after_state)
+		VALUES ($1, $2, $3, $4, $5, $6)`
+
+	_, err := tx.Exec(context.Background(), query, entry.Actor, entry.Action, entry.EntityType, entry.EntityID, nullableJSON(entry.Before), nullableJSON(entry.After))
+	return err
+}
+
+// nullableJSON passes a missing state as NULL and any other as json text.
+func nullableJSON(data []byte) *string {
+	if data == nil {
+		return nil
+	}
+	s := string(data)
+	return &s
+}
+
+// GetEntries returns the entries matching filter, newest first.
+func (c *AuditDB) GetEntries(filter Filter) ([]Entry, error) {
+	query := "SELECT audit_id, actor, action, entity_type, entity_id, before_state, after_state, created_at FROM audit_log WHERE 1=1"
+	var params []interface{}
+	addParam := func(param interface{}) string {
+		params = append(params, param)
+		return fmt.Sprintf("$%d", len(params))
+	}
+
+	if filter.Actor != "" {
+		query += " AND actor = " + addParam(filter.Actor)
+	}
+	if filter.Action != "" {
+		query += " AND action = " + addParam(filter.Action)
+	}
+	if filter.EntityType != "" {
+		query += " AND entity_type = " + addParam(filter.EntityType)
+	}
+	if filter.EntityID != "" {
+		query += " AND entity_id = " + addParam(filter.EntityID)
+	}
+	if !filter.From.IsZero() {
+		query += " AND created_at >= " + addParam(filter.From)
+	}
+	if !filter.To.IsZero() {
+		query += " AND created_at < " + addParam(filter.To)
+	}
+	if filter.BeforeID > 0 {
+		query += " AND audit_id < " + addParam(filter.BeforeID)
+	}
+	query += " ORDER BY audit_id DESC LIMIT " + addParam(filter.Limit)
+
+	rows, err := c.db.Query(context.Background(), query, params...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+	var entries []Entry
+	for rows.Next() {
+		var entry Entry
+		var before, after *string
+		err := rows.Scan(&entry.AuditID, &entry.Actor, &entry.Action, &entry.EntityType, &entry.EntityID, &before, &after, &entry.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		if before != nil {
+			entry.Before = []byte(*before)
+		}
+		if after != nil {
+			entry.After = []byte(*after)
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
@@ -0,0 +1,90 @@
+package audit
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const maxPageLimit = 1000
+
+type AuditAPI struct {
+	s *AuditService
+}
+
+func NewAuditAPI(s *AuditService) *AuditAPI {
+	return &AuditAPI{
+		s: s,
+	}
+}
+
+// HandleGetAudit lists the audit log newest first. The X-Next-Cursor header holds the
+// cursor parameter of the next page when the page is full.
+func (api *AuditAPI) HandleGetAudit(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := api.s.GetEntries(filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// dont return null
+	if result == nil {
+		result = []Entry{}
+	}
+
+	jsonResponse, err := json.Marshal(result)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if len(result) == filter.Limit {
+		w.Header().Set("X-Next-Cursor", strconv.Itoa(result[len(result)-1].AuditID))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
+
+func parseFilter(r *http.Request) (Filter, error) {
+	query := r.URL.Query()
+	filter := Filter{
+		Actor:      query.Get("actor"),
+		Action:     query.Get("action"),
+		EntityType: query.Get("entityType"),
+		EntityID:   query.Get("entityId"),
+		Limit:      100,
+	}
+
+	var err error
+	if from := query.Get("from"); from != "" {
+		if filter.From, err = time.Parse(time.RFC3339, from); err != nil {
+			return filter, errors.New("invalid from, expected RFC 3339: " + err.Error())
+		}
+	}
+	if to := query.Get("to"); to != "" {
+		if filter.To, err = time.Parse(time.RFC3339, to); err != nil {
+			return filter, errors.New("invalid to, expected RFC 3339: " + err.Error())
+		}
+	}
+	if cursor := query.Get("cursor"); cursor != "" {
+		if filter.BeforeID, err = strconv.Atoi(cursor); err != nil || filter.BeforeID <= 0 {
+			return filter, errors.New("invalid cursor")
+		}
+	}
+	if limit := query.Get("limit"); limit != "" {
+		filter.Limit, err = strconv.Atoi(limit)
+		if err != nil || filter.Limit <= 0 || filter.Limit > maxPageLimit {
+			return filter, errors.New("limit must be between 1 and " + strconv.Itoa(maxPageLimit))
+		}
+	}
+	return filter, nil
+}
@@ -0,0 +1,133 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"time"
+)
+
+var ErrRefreshTokenNotFound = errors.New("refresh token does not exist")
+
+type RefreshToken struct {
+	UserID    int
+	ExpiresAt time.Time
+	RevokedAt *time.Time
+}
+
+type LoginAttempt struct {
+	AttemptID   int       `json:"id"`
+	Username    string    `json:"username"`
+	IPAddress   string    `json:"ipAddress"`
+	Success     bool      `json:"success"`
+	Reason      string    `json:"reason"`
+	AttemptedAt time.Time `json:"attemptedAt"`
+}
+
+type AuthDB struct {
+	db *pgxpool.Pool
+}
+
+func NewAuthDB(db *pgxpool.Pool) *AuthDB {
+	return &AuthDB{
+		db: db,
+	}
+}
+
+func (c *AuthDB) PutRefreshToken(userID int, tokenHash string, expiresAt time.Time) error {
+	query := "INSERT INTO refresh_tokens (user_id, token_hash, expires_at) VALUES ($1, $2, $3)"
+	_, err := c.db.Exec(context.Background(), query, userID, tokenHash, expiresAt)
+	return err
+}
+
+func (c *AuthDB) GetRefreshToken(tokenHash string) (RefreshToken, error) {
+	query := "SELECT user_id, expires_at, revoked_at FROM refresh_tokens WHERE token_hash = $1"
+
+	var refreshToken RefreshToken
+	err := c.db.QueryRow(context.Background(), query, tokenHash).Scan(&refreshToken.UserID, &refreshToken.ExpiresAt, &refreshToken.RevokedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return refreshToken, ErrRefreshTokenNotFound
+	}
+	return refreshToken, err
+}
+
+// RevokeRefreshToken marks the token revoked and reports whether it was still active.
+func (c *AuthDB) RevokeRefreshToken(tokenHash string) (bool, error) {
+	sql := `UPDATE refresh_tokens SET revoked_at = now() WHERE token_hash = $1 AND revoked_at IS NULL`
+
+	res, err := c.db.Exec(context.Background(), sql, tokenHash)
+	if err != nil {
+		return false, err
+	}
+	return res.RowsAffected() > 0, nil
+}
+
+func (c *AuthDB) RevokeUserRefreshTokens(userID int) error {
+	sql := `UPDATE refresh_tokens SET revoked_at = now() WHERE user_id = $1 AND revoked_at IS NULL`
+	_, err := c.db.Exec(context.Background(), sql, userID)
+	return err
+}
+
+func (c *AuthDB) RevokeAccessToken(tokenID string, expiresAt time.Time) error {
+	sql := `INSERT INTO revoked_tokens (token_id, expires_at) VALUES ($1, $2) ON CONFLICT (token_id) DO NOTHING`
+	_, err := c.db.Exec(context.Background(), sql, tokenID, expiresAt)
+	return err
+}
+
+func (c *AuthDB) IsAccessTokenRevoked(tokenID string) (bool, error) {
+	query := "SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE token_id = $1)"
+
+	var revoked bool
+	err := c.db.QueryRow(context.Background(), query, tokenID).Scan(&revoked)
+	return revoked, err
+}
+
+// DeleteExpiredTokens drops rows that can no longer be used anyway.
+func (c *AuthDB) DeleteExpiredTokens() error {
+	ctx := context.Background()
+	if _, err := c.db.Exec(ctx, "DELETE FROM revoked_tokens WHERE expires_at < now()"); err != nil {
+		return err
+	}
+	_, err := c.db.Exec(ctx, "DELETE FROM refresh_tokens WHERE expires_at < now()")
+	return err
+}
+
+func (c *AuthDB) PutLoginAttempt(attempt LoginAttempt) error {
+	query := "INSERT INTO login_attempts (username, ip_address, success, reason) VALUES ($1, $2, $3, $4)"
+	_, err := c.db.Exec(context.Background(), query, attempt.Username, attempt.IPAddress, attempt.Success, attempt.Reason)
+	return err
+}
+
+// GetLoginAttempts returns the latest attempts first, optionally only those for username.
+func (c *AuthDB) GetLoginAttempts(username string, limit int) ([]LoginAttempt, error) {
+	query := `
+		SELECT attempt_id, username, ip_address, success, reason, attempted_at
+		FROM login_attempts
+		WHERE $1 = '' OR username = $1
+		ORDER BY attempted_at DESC
+		LIMIT $2
+	`
+
+	var attempts []LoginAttempt
+	rows, err := c.db.Query(context.Background(), query, username, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+	for rows.Next() {
+		var attempt LoginAttempt
+		err := rows.Scan(&attempt.AttemptID, &attempt.Username, &attempt.IPAddress, &attempt.Success, &attempt.Reason, &attempt.AttemptedAt)
+		if err != nil {
+			return nil, err
+		}
+		attempts = append(attempts, attempt)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return attempts, nil
+}
@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// defaultKeyID names the key given through the legacy JWT_KEY setting. Tokens issued before
+// key ids existed carry none and are verified with the key of this id only, so a deployment
+// moving from JWT_KEY to a key ring lists the old secret as "default:<secret>" until they expire.
+const defaultKeyID = "default"
+
+// KeyRing holds every key tokens may be verified with and the one new tokens are signed with.
+// Rotating means adding a new key, making it the signing key and dropping the old one
+// only after the tokens it signed have expired.
+type KeyRing struct {
+	signingKeyID string
+	keys         map[string][]byte
+}
+
+func NewKeyRing(signingKeyID string, keys map[string][]byte) (*KeyRing, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("no jwt signing keys configured")
+	}
+	for kid, key := range keys {
+		if kid == "" {
+			return nil, errors.New("jwt signing key with empty key id")
+		}
+		if len(key) == 0 {
+			return nil, fmt.Errorf("jwt signing key %q is empty", kid)
+		}
+	}
+	if _, ok := keys[signingKeyID]; !ok {
+		return nil, fmt.Errorf("jwt signing key %q not found", signingKeyID)
+	}
+
+	return &KeyRing{
+		signingKeyID: signingKeyID,
+		keys:         keys,
+	}, nil
+}
+
+// NewLegacyKeyRing holds the single key of the legacy JWT_KEY setting under defaultKeyID. The
+// key is used exactly as given, so tokens signed with it before key ids existed stay valid.
+func NewLegacyKeyRing(key []byte) (*KeyRing, error) {
+	return NewKeyRing(defaultKeyID, map[string][]byte{defaultKeyID: key})
+}
+
+// ParseKeyRing reads keys written as "kid:secret" separated by commas or newlines.
+// An empty signingKeyID selects the first key listed.
+func ParseKeyRing(value, signingKeyID string) (*KeyRing, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, errors.New("no jwt signing keys configured")
+	}
+
+	keys := make(map[string][]byte)
+	entries := strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == '\n' })
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+
+		kid, secret, found := strings.Cut(entry, ":")
+		if !found {
+			return nil, fmt.Errorf("jwt signing key entry %q is not in kid:secret form", entry)
+		}
+		kid = strings.TrimSpace(kid)
+		if _, ok := keys[kid]; ok {
+			return nil, fmt.Errorf("duplicate jwt signing key id %q", kid)
+		}
+		keys[kid] = []byte(strings.TrimSpace(secret))
+		if signingKeyID == "" {
+			signingKeyID = kid
+		}
+	}
+
+	return NewKeyRing(signingKeyID, keys)
+}
+
+func (k *KeyRing) SigningKey() (string, []byte) {
+	return k.signingKeyID, k.keys[k.signingKeyID]
+}
+
+// VerificationKey returns the key for the given key id. Tokens issued before key ids were
+// introduced carry none, they were signed with the legacy JWT_KEY and are checked against
+// the key of defaultKeyID, never against whatever key happens to sign new tokens.
+func (k *KeyRing) VerificationKey(kid string) ([]byte, error) {
+	if kid == "" {
+		key, ok := k.keys[defaultKeyID]
+		if !ok {
+			return nil, errors.New("token without a key id")
+		}
+		return key, nil
+	}
+	key, ok := k.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+	return key, nil
+}
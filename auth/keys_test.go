@@ -0,0 +1,45 @@
+package auth
+
+import "testing"
+
+func TestVerificationKey(t *testing.T) {
+	legacy, err := NewLegacyKeyRing([]byte("legacy"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := ParseKeyRing("k2:new, default:legacy", "k2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fresh, err := ParseKeyRing("k1:old\nk2:new", "k2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		keys    *KeyRing
+		kid     string
+		want    string
+		wantErr bool
+	}{
+		{name: "legacy key without kid", keys: legacy, kid: "", want: "legacy"},
+		{name: "legacy key by kid", keys: legacy, kid: defaultKeyID, want: "legacy"},
+		{name: "no kid after rotating away from the legacy key", keys: rotated, kid: "", want: "legacy"},
+		{name: "signing key by kid", keys: rotated, kid: "k2", want: "new"},
+		{name: "no kid without a legacy key", keys: fresh, kid: "", wantErr: true},
+		{name: "older key by kid", keys: fresh, kid: "k1", want: "old"},
+		{name: "unknown kid", keys: fresh, kid: "k3", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := tt.keys.VerificationKey(tt.kid)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error is %v, want an error %v", err, tt.wantErr)
+			}
+			if string(key) != tt.want {
+				t.Errorf("key is %q, want %q", key, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,495 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"tzcnlr/user"
+)
+
+const (
+	oidcStateCookie   = "oidc_state"
+	oidcStateLifetime = 10 * time.Minute
+	// unknown key ids trigger a jwks refetch at most this often
+	jwksRefetchInterval = time.Minute
+)
+
+type OIDCConfig struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	// UsernameClaim is the id token claim naming users created on their first login, users are
+	// linked by issuer and sub and never found by it
+	UsernameClaim string
+	// RoleClaim is the id token claim, a string or a list of strings, mapped through RoleMapping
+	RoleClaim   string
+	RoleMapping map[string]string
+	// AutoCreateUsers provisions unknown users on their first login
+	AutoCreateUsers bool
+	// FrontendRedirectURL receives the tokens in its fragment after a successful login,
+	// without it the callback responds with the tokens as json
+	FrontendRedirectURL string
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// OIDCProvider talks to an OpenID Connect identity provider, discovery and keys are
+// fetched lazily and cached so the server starts even while the provider is down.
+type OIDCProvider struct {
+	config OIDCConfig
+	client *http.Client
+
+	mu            sync.Mutex
+	discovery     *oidcDiscovery
+	keys          map[string]interface{}
+	keysFetchedAt time.Time
+}
+
+func NewOIDCProvider(config OIDCConfig, client *http.Client) *OIDCProvider {
+	if config.UsernameClaim == "" {
+		config.UsernameClaim = "preferred_username"
+	}
+	return &OIDCProvider{
+		config: config,
+		client: client,
+	}
+}
+
+// ParseRoleMapping reads "idpValue:role" pairs separated by commas.
+func ParseRoleMapping(value string) (map[string]string, error) {
+	mapping := make(map[string]string)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		idpValue, role, found := strings.Cut(entry, ":")
+		if !found {
+			return nil, fmt.Errorf("role mapping entry %q is not in value:role form", entry)
+		}
+		mapping[strings.TrimSpace(idpValue)] = strings.TrimSpace(role)
+	}
+	return mapping, nil
+}
+
+func (p *OIDCProvider) getDiscovery(ctx context.Context) (*oidcDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	var discovery oidcDiscovery
+	err := p.getJSON(ctx, strings.TrimSuffix(p.config.Issuer, "/")+"/.well-known/openid-configuration", &discovery)
+	if err != nil {
+		return nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+	if discovery.Issuer != p.config.Issuer {
+		return nil, fmt.Errorf("oidc discovery: issuer %q does not match configured %q", discovery.Issuer, p.config.Issuer)
+	}
+
+	p.discovery = &discovery
+	return p.discovery, nil
+}
+
+func (p *OIDCProvider) AuthCodeURL(ctx context.Context, state, nonce string) (string, error) {
+	discovery, err := p.getDiscovery(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", p.config.ClientID)
+	params.Set("redirect_uri", p.config.RedirectURL)
+	params.Set("scope", "openid profile email")
+	params.Set("state", state)
+	params.Set("nonce", nonce)
+
+	separator := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return discovery.AuthorizationEndpoint + separator + params.Encode(), nil
+}
+
+// Exchange redeems an authorization code and returns the raw id token.
+func (p *OIDCProvider) Exchange(ctx context.Context, code string) (string, error) {
+	discovery, err := p.getDiscovery(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.config.RedirectURL)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("oidc token request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", fmt.Errorf("oidc token response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("oidc token request failed with status %d: %s", resp.StatusCode, body)
+	}
+
+	var tokenResponse struct {
+		IDToken string `json:"id_token"`
+	}
+	if err = json.Unmarshal(body, &tokenResponse); err != nil {
+		return "", fmt.Errorf("oidc token response: %w", err)
+	}
+	if tokenResponse.IDToken == "" {
+		return "", errors.New("oidc token response without id_token")
+	}
+	return tokenResponse.IDToken, nil
+}
+
+// VerifyIDToken checks signature, issuer, audience, expiry and nonce of an id token.
+func (p *OIDCProvider) VerifyIDToken(ctx context.Context, rawIDToken, nonce string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	token, err := jwt.ParseWithClaims(rawIDToken, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.verificationKey(ctx, kid)
+	},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512"}),
+		jwt.WithIssuer(p.config.Issuer),
+		jwt.WithAudience(p.config.ClientID),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil || !token.Valid {
+		return nil, fmt.Errorf("invalid id token: %w", err)
+	}
+
+	if tokenNonce, _ := claims["nonce"].(string); tokenNonce != nonce {
+		return nil, errors.New("invalid id token: nonce mismatch")
+	}
+	return claims, nil
+}
+
+// MapClaims derives the username of new users and the role from verified id token claims,
+// the role is empty if no claim value is mapped.
+func (p *OIDCProvider) MapClaims(claims jwt.MapClaims) (string, string, error) {
+	username, _ := claims[p.config.UsernameClaim].(string)
+	if username == "" {
+		return "", "", fmt.Errorf("id token claim %q not set", p.config.UsernameClaim)
+	}
+
+	var values []string
+	switch claim := claims[p.config.RoleClaim].(type) {
+	case string:
+		values = []string{claim}
+	case []interface{}:
+		for _, v := range claim {
+			if s, ok := v.(string); ok {
+				values = append(values, s)
+			}
+		}
+	}
+
+	// several mapped values grant the most privileged role among them
+	rank := map[string]int{user.RoleViewer: 1, user.RoleDispatcher: 2, user.RoleAdmin: 3}
+	role := ""
+	for _, v := range values {
+		if mapped, ok := p.config.RoleMapping[v]; ok && rank[mapped] > rank[role] {
+			role = mapped
+		}
+	}
+	return strings.ToLower(username), role, nil
+}
+
+func (p *OIDCProvider) verificationKey(ctx context.Context, kid string) (interface{}, error) {
+	p.mu.Lock()
+	key, ok := p.keys[kid]
+	stale := time.Since(p.keysFetchedAt) > jwksRefetchInterval
+	p.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+	if !stale {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+
+	if err := p.fetchKeys(ctx); err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if key, ok = p.keys[kid]; ok {
+		return key, nil
+	}
+	// providers with a single key may leave kid out
+	if kid == "" && len(p.keys) == 1 {
+		for _, key = range p.keys {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown key id %q", kid)
+}
+
+func (p *OIDCProvider) fetchKeys(ctx context.Context) error {
+	discovery, err := p.getDiscovery(ctx)
+	if err != nil {
+		return err
+	}
+
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err = p.getJSON(ctx, discovery.JWKSURI, &jwks); err != nil {
+		return fmt.Errorf("oidc jwks: %w", err)
+	}
+
+	keys := make(map[string]interface{})
+	for _, jwk := range jwks.Keys {
+		key, err := jwk.publicKey()
+		if err != nil {
+			// keys of unsupported types are skipped, tokens signed with them fail verification
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+
+	p.mu.Lock()
+	p.keys = keys
+	p.keysFetchedAt = time.Now()
+	p.mu.Unlock()
+	return nil
+}
+
+func (p *OIDCProvider) getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: status %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+func (k jsonWebKey) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+type OIDCAPI struct {
+	api *AuthAPI
+	p   *OIDCProvider
+}
+
+func NewOIDCAPI(api *AuthAPI, p *OIDCProvider) *OIDCAPI {
+	return &OIDCAPI{
+		api: api,
+		p:   p,
+	}
+}
+
+// HandleLogin redirects to the identity provider, state and nonce travel in a cookie
+// so the callback can be served by any replica.
+func (o *OIDCAPI) HandleLogin(w http.ResponseWriter, r *http.Request) {
+	state, err := generateRandomString(16)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	nonce, err := generateRandomString(16)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	authURL, err := o.p.AuthCodeURL(r.Context(), state, nonce)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state + "." + nonce,
+		Path:     "/",
+		MaxAge:   int(oidcStateLifetime.Seconds()),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(o.p.config.RedirectURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+func (o *OIDCAPI) HandleCallback(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if idpError := query.Get("error"); idpError != "" {
+		http.Error(w, "identity provider error: "+idpError, http.StatusUnauthorized)
+		return
+	}
+
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil {
+		http.Error(w, "oidc state cookie missing", http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Path: "/", MaxAge: -1})
+
+	state, nonce, _ := strings.Cut(cookie.Value, ".")
+	if state == "" || query.Get("state") != state {
+		http.Error(w, "oidc state mismatch", http.StatusBadRequest)
+		return
+	}
+
+	code := query.Get("code")
+	if code == "" {
+		http.Error(w, "authorization code not provided", http.StatusBadRequest)
+		return
+	}
+
+	rawIDToken, err := o.p.Exchange(r.Context(), code)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	claims, err := o.p.VerifyIDToken(r.Context(), rawIDToken, nonce)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	username, role, err := o.p.MapClaims(claims)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	subject, _ := claims.GetSubject()
+	if subject == "" {
+		http.Error(w, "id token claim \"sub\" not set", http.StatusUnauthorized)
+		return
+	}
+	identity := user.ExternalIdentity{Issuer: o.p.config.Issuer, Subject: subject}
+
+	u, err := o.api.us.SyncExternalUser(identity, username, role, o.p.config.AutoCreateUsers)
+	if errors.Is(err, user.ErrUserNotFound) || errors.Is(err, user.ErrLocalAccount) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if errors.Is(err, user.ErrUsernameTaken) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	o.api.recordLoginAttempt(LoginAttempt{Username: u.Username, IPAddress: o.api.clientIP(r), Success: true, Reason: "oidc"})
+
+	if o.p.config.FrontendRedirectURL == "" {
+		o.api.writeTokens(w, u)
+		return
+	}
+
+	tokens, err := o.api.issueTokens(u)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fragment := url.Values{}
+	fragment.Set("accessToken", tokens.AccessToken)
+	fragment.Set("refreshToken", tokens.RefreshToken)
+	fragment.Set("expiresIn", fmt.Sprint(tokens.ExpiresIn))
+	http.Redirect(w, r, o.p.config.FrontendRedirectURL+"#"+fragment.Encode(), http.StatusFound)
+}
@@ -0,0 +1,217 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+	"tzcnlr/stubidp"
+	"tzcnlr/user"
+)
+
+const testRedirectURL = "http://app.test/oidc/callback"
+
+// startStubIDP serves a stub provider on a local port, the issuer is only known once the
+// listener is open.
+func startStubIDP(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewUnstartedServer(nil)
+	idp, err := stubidp.New(stubidp.Config{
+		Issuer:       "http://" + server.Listener.Addr().String(),
+		ClientID:     "tzcnlr",
+		ClientSecret: "secret",
+		Username:     "Operator",
+		Roles:        []string{"ops", "staff"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Config.Handler = idp.Handler()
+	server.Start()
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newTestProvider(server *httptest.Server) *OIDCProvider {
+	return NewOIDCProvider(OIDCConfig{
+		Issuer:       server.URL,
+		ClientID:     "tzcnlr",
+		ClientSecret: "secret",
+		RedirectURL:  testRedirectURL,
+		RoleClaim:    "roles",
+		RoleMapping:  map[string]string{"staff": user.RoleViewer, "ops": user.RoleDispatcher},
+	}, &http.Client{Timeout: 5 * time.Second})
+}
+
+// authorize follows an authorization URL to the stub provider and returns the callback
+// request it redirects to.
+func authorize(t *testing.T, authURL string) *http.Request {
+	t.Helper()
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("authorize answered %d, want %d", resp.StatusCode, http.StatusFound)
+	}
+
+	location := resp.Header.Get("Location")
+	if !strings.HasPrefix(location, testRedirectURL+"?") {
+		t.Fatalf("authorize redirected to %q, want the callback", location)
+	}
+	return httptest.NewRequest(http.MethodGet, location, nil)
+}
+
+func TestOIDCLoginFlow(t *testing.T) {
+	server := startStubIDP(t)
+	p := newTestProvider(server)
+	ctx := context.Background()
+
+	authURL, err := p.AuthCodeURL(ctx, "the-state", "the-nonce")
+	if err != nil {
+		t.Fatalf("discovery: %v", err)
+	}
+	if !strings.HasPrefix(authURL, server.URL+"/authorize?") {
+		t.Fatalf("authorization url %q is not the discovered endpoint", authURL)
+	}
+
+	callback := authorize(t, authURL)
+	query := callback.URL.Query()
+	if query.Get("state") != "the-state" {
+		t.Fatalf("state %q came back, want the-state", query.Get("state"))
+	}
+
+	rawIDToken, err := p.Exchange(ctx, query.Get("code"))
+	if err != nil {
+		t.Fatalf("token exchange: %v", err)
+	}
+
+	claims, err := p.VerifyIDToken(ctx, rawIDToken, "the-nonce")
+	if err != nil {
+		t.Fatalf("verifying the id token against the jwks: %v", err)
+	}
+	if subject, _ := claims.GetSubject(); subject != "Operator" {
+		t.Errorf("sub is %q, want Operator", subject)
+	}
+
+	username, role, err := p.MapClaims(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if username != "operator" {
+		t.Errorf("username is %q, want operator", username)
+	}
+	if role != user.RoleDispatcher {
+		t.Errorf("role is %q, want the most privileged mapped role %q", role, user.RoleDispatcher)
+	}
+
+	if _, err = p.Exchange(ctx, query.Get("code")); err == nil {
+		t.Error("an authorization code was redeemed twice")
+	}
+}
+
+func TestOIDCHandleLoginSetsStateCookie(t *testing.T) {
+	server := startStubIDP(t)
+	o := NewOIDCAPI(nil, newTestProvider(server))
+
+	w := httptest.NewRecorder()
+	o.HandleLogin(w, httptest.NewRequest(http.MethodGet, "/oidc/login", nil))
+	if w.Code != http.StatusFound {
+		t.Fatalf("login answered %d, want %d", w.Code, http.StatusFound)
+	}
+
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != oidcStateCookie {
+		t.Fatalf("login set cookies %v, want %s", cookies, oidcStateCookie)
+	}
+	state, nonce, _ := strings.Cut(cookies[0].Value, ".")
+
+	authURL, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if authURL.Query().Get("state") != state || authURL.Query().Get("nonce") != nonce {
+		t.Errorf("authorization url %q does not carry the state and nonce of the cookie %q", authURL, cookies[0].Value)
+	}
+}
+
+func TestOIDCCallbackRejectsMismatches(t *testing.T) {
+	server := startStubIDP(t)
+	p := newTestProvider(server)
+	// the rejections happen before any user is looked up, so no AuthAPI is needed
+	o := NewOIDCAPI(nil, p)
+
+	tests := []struct {
+		name      string
+		cookie    string
+		state     string
+		wantCode  int
+		wantError string
+	}{
+		{name: "state mismatch", cookie: "the-state.the-nonce", state: "forged-state", wantCode: http.StatusBadRequest, wantError: "oidc state mismatch"},
+		{name: "empty state", cookie: ".the-nonce", state: "", wantCode: http.StatusBadRequest, wantError: "oidc state mismatch"},
+		{name: "nonce mismatch", cookie: "the-state.forged-nonce", state: "the-state", wantCode: http.StatusUnauthorized, wantError: "nonce mismatch"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authURL, err := p.AuthCodeURL(context.Background(), "the-state", "the-nonce")
+			if err != nil {
+				t.Fatal(err)
+			}
+			callback := authorize(t, authURL)
+
+			query := callback.URL.Query()
+			query.Set("state", tt.state)
+			callback.URL.RawQuery = query.Encode()
+			callback.AddCookie(&http.Cookie{Name: oidcStateCookie, Value: tt.cookie})
+
+			w := httptest.NewRecorder()
+			o.HandleCallback(w, callback)
+			if w.Code != tt.wantCode {
+				t.Fatalf("callback answered %d %q, want %d", w.Code, w.Body.String(), tt.wantCode)
+			}
+			if !strings.Contains(w.Body.String(), tt.wantError) {
+				t.Errorf("callback answered %q, want it to mention %q", w.Body.String(), tt.wantError)
+			}
+		})
+	}
+}
+
+func TestOIDCCallbackWithoutStateCookie(t *testing.T) {
+	server := startStubIDP(t)
+	o := NewOIDCAPI(nil, newTestProvider(server))
+
+	w := httptest.NewRecorder()
+	o.HandleCallback(w, httptest.NewRequest(http.MethodGet, "/oidc/callback?state=s&code=c", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("callback answered %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestOIDCRejectsTokensOfOtherProviders(t *testing.T) {
+	server := startStubIDP(t)
+	other := startStubIDP(t)
+	ctx := context.Background()
+
+	// the other provider signs with its own key and names itself as issuer
+	otherProvider := newTestProvider(other)
+	authURL, err := otherProvider.AuthCodeURL(ctx, "state", "nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rawIDToken, err := otherProvider.Exchange(ctx, authorize(t, authURL).URL.Query().Get("code"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = newTestProvider(server).VerifyIDToken(ctx, rawIDToken, "nonce"); err == nil {
+		t.Error("an id token of another provider was accepted")
+	}
+}
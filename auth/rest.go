@@ -5,25 +5,44 @@ import (
 	"encoding/json"
 	"errors"
 	"github.com/golang-jwt/jwt/v5"
+	"log"
+	"math"
+	"net"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
+	"tzcnlr/apikey"
+	"tzcnlr/user"
 )
 
 type AuthAPI struct {
-	adminUsername string
-	adminPassword string
-	JWTSecretKey  []byte
+	us        *user.UserService
+	ks        *apikey.APIKeyService
+	aDB       *AuthDB
+	keys      *KeyRing
+	throttler *LoginThrottler
+	// trustedProxies are the reverse proxies whose X-Forwarded-For header is believed
+	trustedProxies []*net.IPNet
 }
 
-func NewAuthAPI(adminUsername, adminPassword string, JWTSecretKey []byte) *AuthAPI {
+func NewAuthAPI(us *user.UserService, ks *apikey.APIKeyService, aDB *AuthDB, keys *KeyRing, trustedProxies []*net.IPNet) *AuthAPI {
 	return &AuthAPI{
-		adminUsername: strings.ToLower(adminUsername),
-		adminPassword: adminPassword,
-		JWTSecretKey:  JWTSecretKey,
+		us:             us,
+		ks:             ks,
+		aDB:            aDB,
+		keys:           keys,
+		throttler:      NewLoginThrottler(),
+		trustedProxies: trustedProxies,
 	}
 }
 
+type Claims struct {
+	Role        string `json:"role"`
+	CompanyName string `json:"companyName,omitempty"`
+	jwt.RegisteredClaims
+}
+
 type Credentials struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
@@ -63,6 +82,8 @@ func (api *AuthAPI) DecodeCredentialsBodyHandler(next http.Handler) http.Handler
 	})
 }
 
+// LoginHandler answers valid credentials with a TokenResponse. Clients written before refresh
+// tokens existed read the bare access token from the body and have to read accessToken instead.
 func (api *AuthAPI) LoginHandler(w http.ResponseWriter, r *http.Request) {
 	credentials, ok := r.Context().Value("credentials").(Credentials)
 	if !ok {
@@ -70,31 +91,253 @@ func (api *AuthAPI) LoginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	credentials.Username = strings.ToLower(credentials.Username)
-	if credentials.Username != api.adminUsername || credentials.Password != api.adminPassword {
-		http.Error(w, "wrong credentials", http.StatusUnauthorized)
+	ip := api.clientIP(r)
+	attempt := LoginAttempt{Username: credentials.Username, IPAddress: ip}
+
+	result, wait := api.throttler.Check(credentials.Username, ip)
+	if result != Allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		if result == Locked {
+			attempt.Reason = "locked"
+			api.recordLoginAttempt(attempt)
+			http.Error(w, "account temporarily locked", http.StatusLocked)
+			return
+		}
+		attempt.Reason = "throttled"
+		api.recordLoginAttempt(attempt)
+		http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+		return
+	}
+
+	u, err := api.us.Authenticate(credentials.Username, credentials.Password)
+	if errors.Is(err, user.ErrWrongCredentials) {
+		api.throttler.RegisterFailure(credentials.Username, ip)
+		attempt.Reason = "wrong credentials"
+		api.recordLoginAttempt(attempt)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	api.throttler.RegisterSuccess(credentials.Username)
+	attempt.Success = true
+	attempt.Reason = "ok"
+	api.recordLoginAttempt(attempt)
+
+	api.writeTokens(w, u)
+}
+
+func (api *AuthAPI) HandleGetLoginAttempts(w http.ResponseWriter, r *http.Request) {
+	username := strings.ToLower(r.URL.Query().Get("username"))
+
+	limit := 100
+	if limitParam := r.URL.Query().Get("limit"); limitParam != "" {
+		var err error
+		limit, err = strconv.Atoi(limitParam)
+		if err != nil || limit <= 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+
+	result, err := api.aDB.GetLoginAttempts(username, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// dont return null
+	if result == nil {
+		result = []LoginAttempt{}
+	}
+
+	jsonResponse, err := json.Marshal(result)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
+
+// recordLoginAttempt only logs failures to store the attempt, a login must not fail because of it.
+func (api *AuthAPI) recordLoginAttempt(attempt LoginAttempt) {
+	if err := api.aDB.PutLoginAttempt(attempt); err != nil {
+		log.Printf("error recording login attempt: %v\n", err)
+	}
+}
+
+// RefreshHandler exchanges a valid refresh token for a new token pair,
+// the presented refresh token is revoked so every refresh token is single use.
+func (api *AuthAPI) RefreshHandler(w http.ResponseWriter, r *http.Request) {
+	body, ok := r.Context().Value("body").([]byte)
+	if !ok {
+		http.Error(w, "error accessing the body of the request", http.StatusInternalServerError)
+		return
+	}
+
+	var req refreshRequest
+	if err := json.Unmarshal(body, &req); err != nil || req.RefreshToken == "" {
+		http.Error(w, "refresh token not provided in request body", http.StatusBadRequest)
+		return
+	}
+
+	tokenHash := hashToken(req.RefreshToken)
+	refreshToken, err := api.aDB.GetRefreshToken(tokenHash)
+	if errors.Is(err, ErrRefreshTokenNotFound) {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if refreshToken.RevokedAt != nil {
+		// a revoked token being replayed means it leaked, log the user out everywhere
+		if err = api.aDB.RevokeUserRefreshTokens(refreshToken.UserID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		http.Error(w, "refresh token revoked", http.StatusUnauthorized)
+		return
+	}
+	if time.Now().After(refreshToken.ExpiresAt) {
+		http.Error(w, "refresh token expired", http.StatusUnauthorized)
+		return
+	}
+
+	revoked, err := api.aDB.RevokeRefreshToken(tokenHash)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !revoked {
+		// lost a race against a concurrent refresh with the same token
+		http.Error(w, "refresh token revoked", http.StatusUnauthorized)
+		return
+	}
+
+	u, err := api.us.GetUserByID(refreshToken.UserID)
+	if errors.Is(err, user.ErrUserNotFound) {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	api.writeTokens(w, u)
+}
+
+// LogoutHandler revokes the access token of the request and, if given, the refresh token in the body.
+// It has to run after ValidateTokenMiddleware.
+func (api *AuthAPI) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value("claims").(Claims)
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if err := api.aDB.RevokeAccessToken(claims.ID, claims.ExpiresAt.Time); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	body, _ := r.Context().Value("body").([]byte)
+	if len(body) == 0 {
+		return
+	}
+
+	var req refreshRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.RefreshToken != "" {
+		if _, err := api.aDB.RevokeRefreshToken(hashToken(req.RefreshToken)); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// issueTokens creates an access token and persists a new refresh token for u.
+func (api *AuthAPI) issueTokens(u user.User) (TokenResponse, error) {
+	accessToken, err := api.GenerateJWT(u)
+	if err != nil {
+		return TokenResponse{}, err
+	}
+
+	refreshToken, err := generateRandomString(32)
+	if err != nil {
+		return TokenResponse{}, err
+	}
+
+	err = api.aDB.PutRefreshToken(u.UserID, hashToken(refreshToken), time.Now().Add(refreshTokenLifetime))
+	if err != nil {
+		return TokenResponse{}, err
+	}
+
+	return TokenResponse{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(accessTokenLifetime.Seconds()),
+	}, nil
+}
+
+func (api *AuthAPI) writeTokens(w http.ResponseWriter, u user.User) {
+	tokens, err := api.issueTokens(u)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	tokenString, err := api.GenerateJWT()
+	jsonResponse, err := json.Marshal(tokens)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	w.Write([]byte(tokenString))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
 }
 
-func (api *AuthAPI) GenerateJWT() (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, &jwt.RegisteredClaims{
-		ExpiresAt: jwt.NewNumericDate(time.Now().Add(730 * time.Hour)),
+func (api *AuthAPI) GenerateJWT(u user.User) (string, error) {
+	tokenID, err := generateRandomString(16)
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, &Claims{
+		Role:        u.Role,
+		CompanyName: u.CompanyName,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        tokenID,
+			Subject:   u.Username,
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(accessTokenLifetime)),
+		},
 	})
 
-	return token.SignedString(api.JWTSecretKey)
+	kid, key := api.keys.SigningKey()
+	token.Header["kid"] = kid
+
+	return token.SignedString(key)
 }
 
 func (api *AuthAPI) ValidateTokenMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if key := r.Header.Get("X-API-Key"); key != "" {
+			api.serveWithAPIKey(w, r, key, next)
+			return
+		}
 
 		tokenString := r.Header.Get("Authorization")
 		// goofy, fix TODO
@@ -103,17 +346,82 @@ func (api *AuthAPI) ValidateTokenMiddleware(next http.Handler) http.Handler {
 			return
 		}
 		tokenString = tokenString[len("Bearer "):]
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		claims := &Claims{}
+		token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
 			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 				return nil, errors.New("unexpected signing method")
 			}
-			return api.JWTSecretKey, nil
+			kid, _ := token.Header["kid"].(string)
+			return api.keys.VerificationKey(kid)
 		})
 		if err != nil || !token.Valid {
 			w.WriteHeader(http.StatusUnauthorized)
 			return
 		}
 
-		next.ServeHTTP(w, r)
+		revoked, err := api.aDB.IsAccessTokenRevoked(claims.ID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if revoked {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), "claims", *claims)
+		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+// serveWithAPIKey authenticates the request by api key, the key must be scoped
+// to the /api resource being requested.
+func (api *AuthAPI) serveWithAPIKey(w http.ResponseWriter, r *http.Request, key string, next http.Handler) {
+	apiKey, err := api.ks.Authenticate(key)
+	if errors.Is(err, apikey.ErrInvalidAPIKey) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	resource, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/"), "/")
+	if !apiKey.HasScope(resource) {
+		http.Error(w, "api key not scoped for "+resource, http.StatusForbidden)
+		return
+	}
+
+	claims := Claims{
+		Role: apiKey.Role,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject: "apikey:" + apiKey.Name,
+		},
+	}
+	ctx := context.WithValue(r.Context(), "claims", claims)
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// RequireRole only lets requests through whose token carries one of the given roles,
+// it has to run after ValidateTokenMiddleware.
+func (api *AuthAPI) RequireRole(roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := r.Context().Value("claims").(Claims)
+			if !ok {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			for _, role := range roles {
+				if claims.Role == role {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+
+			http.Error(w, "insufficient permissions", http.StatusForbidden)
+		})
+	}
+}
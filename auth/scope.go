@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+var ErrOutOfCompanyScope = errors.New("companyName is outside of the caller's company")
+
+// CompanyScope returns the company the caller is bound to, empty if it may see every company.
+func CompanyScope(ctx context.Context) string {
+	claims, ok := ctx.Value("claims").(Claims)
+	if !ok {
+		return ""
+	}
+	return claims.CompanyName
+}
+
+// ScopeCompanyName restricts a requested companyName to the caller's company.
+// An empty companyName becomes the caller's company, any other company is rejected.
+func ScopeCompanyName(ctx context.Context, companyName string) (string, error) {
+	scope := CompanyScope(ctx)
+	if scope == "" || companyName == scope {
+		return companyName, nil
+	}
+	if companyName == "" {
+		return scope, nil
+	}
+	return "", ErrOutOfCompanyScope
+}
+
+// RequireUnscoped rejects callers bound to a company, for data shared between companies.
+func (api *AuthAPI) RequireUnscoped(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if CompanyScope(r.Context()) != "" {
+			http.Error(w, "insufficient permissions", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Actor names the caller for the audit log, the username or "apikey:" and the key name.
+func Actor(ctx context.Context) string {
+	claims, ok := ctx.Value("claims").(Claims)
+	if !ok {
+		return ""
+	}
+	return claims.Subject
+}
@@ -0,0 +1,146 @@
+package auth
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// failed logins per username before every further try has to wait
+	usernameFreeAttempts = 3
+	// failed logins per username before the account is locked
+	usernameLockoutAttempts = 10
+	lockoutDuration         = 15 * time.Minute
+	// failed logins per ip before it has to wait, higher since offices share addresses
+	ipFreeAttempts = 20
+	maxBackoff     = 15 * time.Minute
+	// failures older than this are forgotten
+	failureMemory = time.Hour
+	sweepInterval = 5 * time.Minute
+)
+
+type ThrottleResult int
+
+const (
+	Allowed ThrottleResult = iota
+	// TooManyAttempts means the caller has to back off before trying again
+	TooManyAttempts
+	// Locked means the account is temporarily locked
+	Locked
+)
+
+type attemptState struct {
+	failures     int
+	lastFailure  time.Time
+	blockedUntil time.Time
+	locked       bool
+}
+
+// LoginThrottler tracks failed logins per username and per ip in memory.
+type LoginThrottler struct {
+	mu         sync.Mutex
+	byUsername map[string]*attemptState
+	byIP       map[string]*attemptState
+	lastSweep  time.Time
+}
+
+func NewLoginThrottler() *LoginThrottler {
+	return &LoginThrottler{
+		byUsername: make(map[string]*attemptState),
+		byIP:       make(map[string]*attemptState),
+	}
+}
+
+// Check reports whether a login for username from ip may be attempted now,
+// and if not, how long the caller has to wait.
+func (t *LoginThrottler) Check(username, ip string) (ThrottleResult, time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	if state, ok := t.byUsername[username]; ok && now.Before(state.blockedUntil) {
+		if state.locked {
+			return Locked, state.blockedUntil.Sub(now)
+		}
+		return TooManyAttempts, state.blockedUntil.Sub(now)
+	}
+	if state, ok := t.byIP[ip]; ok && now.Before(state.blockedUntil) {
+		return TooManyAttempts, state.blockedUntil.Sub(now)
+	}
+	return Allowed, 0
+}
+
+func (t *LoginThrottler) RegisterFailure(username, ip string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	t.sweep(now)
+
+	state := registerFailure(t.byUsername, username, now)
+	if state.failures >= usernameLockoutAttempts {
+		state.locked = true
+		state.blockedUntil = now.Add(lockoutDuration)
+	} else if state.failures >= usernameFreeAttempts {
+		state.blockedUntil = now.Add(backoff(state.failures - usernameFreeAttempts))
+	}
+
+	state = registerFailure(t.byIP, ip, now)
+	if state.failures >= ipFreeAttempts {
+		state.blockedUntil = now.Add(backoff(state.failures - ipFreeAttempts))
+	}
+}
+
+// RegisterSuccess forgets the failures of username, the ip keeps its count
+// so one valid account cannot be used to reset guessing on others.
+func (t *LoginThrottler) RegisterSuccess(username string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.byUsername, username)
+}
+
+func registerFailure(states map[string]*attemptState, key string, now time.Time) *attemptState {
+	state, ok := states[key]
+	if !ok || now.Sub(state.lastFailure) > failureMemory {
+		state = &attemptState{}
+		states[key] = state
+	}
+	if state.locked && now.After(state.blockedUntil) {
+		// lockout served, start counting again from the backoff phase
+		state.locked = false
+		state.failures = usernameFreeAttempts
+	}
+	state.failures++
+	state.lastFailure = now
+	return state
+}
+
+// backoff doubles the wait with every failure starting from one second.
+func backoff(failures int) time.Duration {
+	if failures > 20 {
+		return maxBackoff
+	}
+	wait := time.Duration(math.Pow(2, float64(failures))) * time.Second
+	if wait > maxBackoff {
+		return maxBackoff
+	}
+	return wait
+}
+
+// sweep drops forgotten entries so the maps do not grow without bound.
+func (t *LoginThrottler) sweep(now time.Time) {
+	if now.Sub(t.lastSweep) < sweepInterval {
+		return
+	}
+	t.lastSweep = now
+
+	for _, states := range []map[string]*attemptState{t.byUsername, t.byIP} {
+		for key, state := range states {
+			if now.Sub(state.lastFailure) > failureMemory && now.After(state.blockedUntil) {
+				delete(states, key)
+			}
+		}
+	}
+}
@@ -1,9 +1,25 @@
 package branch
 
+import (
+	"time"
+	"tzcnlr/audit"
+)
+
 type Branch struct {
 	BranchID    int    `json:"id"`
 	BranchName  string `json:"branchName"`
 	CompanyName string `json:"companyName"`
+	// TimeZone is the zone tasks of the branch are recorded in, empty for the company's zone.
+	TimeZone string `json:"timeZone,omitempty"`
+	// ArchivedAt is set for closed branches, which stay known to their past tasks.
+	ArchivedAt *time.Time `json:"archivedAt,omitempty"`
+}
+
+// BranchUpdate is the body of a branch update. A nil TimeZone keeps the zone of the branch, an
+// empty one clears it so the branch follows its company again.
+type BranchUpdate struct {
+	BranchName string  `json:"branchName"`
+	TimeZone   *string `json:"timeZone"`
 }
 
 type BranchService struct {
@@ -16,22 +32,52 @@ func NewBranchService(cDB *BranchDB) *BranchService {
 	}
 }
 
-func (s *BranchService) PutBranch(branch Branch) error {
-	err := s.cDB.PutBranch(branch.CompanyName, branch.BranchName)
+// PutBranch stores a new branch, record writes its audit entry in the same transaction. The
+// other changes take a Recorder the same way.
+func (s *BranchService) PutBranch(branch Branch, record audit.Recorder) error {
+	err := s.cDB.PutBranch(branch.CompanyName, branch.BranchName, branch.TimeZone, record)
 	return err
 }
 
-func (s *BranchService) DeleteBranchByName(companyName, branchName string) error {
-	err := s.cDB.DeleteBranchByName(companyName, branchName)
+func (s *BranchService) DeleteBranchByName(companyName, branchName string, record audit.Recorder) error {
+	err := s.cDB.DeleteBranchByName(companyName, branchName, record)
 	return err
 }
 
-func (s *BranchService) UpdateBranchByName(companyName, branchName string, newBranch Branch) error {
-	err := s.cDB.UpdateBranchByName(companyName, branchName, newBranch.BranchName)
+func (s *BranchService) UpdateBranchByName(companyName, branchName string, update BranchUpdate, record audit.Recorder) error {
+	err := s.cDB.UpdateBranchByName(companyName, branchName, update.BranchName, update.TimeZone, record)
 	return err
 }
 
-func (s *BranchService) GetBranches() ([]Branch, error) {
-	result, err := s.cDB.GetBranches()
+// ArchiveBranchByName closes a branch for new tasks without touching its history.
+func (s *BranchService) ArchiveBranchByName(companyName, branchName string, record audit.Recorder) error {
+	return s.cDB.SetBranchArchived(companyName, branchName, true, record)
+}
+
+func (s *BranchService) RestoreBranchByName(companyName, branchName string, record audit.Recorder) error {
+	return s.cDB.SetBranchArchived(companyName, branchName, false, record)
+}
+
+// GetBranchByName returns a branch of companyName, archived or not.
+func (s *BranchService) GetBranchByName(companyName, branchName string) (Branch, error) {
+	if companyName == "" {
+		return Branch{}, ErrBranchNotFound
+	}
+	branches, err := s.cDB.GetBranches(companyName, true)
+	if err != nil {
+		return Branch{}, err
+	}
+	for _, branch := range branches {
+		if branch.BranchName == branchName {
+			return branch, nil
+		}
+	}
+	return Branch{}, ErrBranchNotFound
+}
+
+// GetBranches returns the branches of companyName, or of every company if it is empty.
+// Archived branches and branches of archived companies need includeArchived.
+func (s *BranchService) GetBranches(companyName string, includeArchived bool) ([]Branch, error) {
+	result, err := s.cDB.GetBranches(companyName, includeArchived)
 	return result, err
 }
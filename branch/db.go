@@ -3,9 +3,18 @@ package branch
 import (
 	"context"
 	"errors"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"strconv"
+	"tzcnlr/audit"
 )
 
+// ErrBranchInUse guards the history, a branch with completed tasks is never deleted.
+var ErrBranchInUse = errors.New("branch has completed tasks and cannot be deleted, archive it instead")
+
+var ErrBranchNotFound = errors.New("branchName does not exist")
+
 type BranchDB struct {
 	db *pgxpool.Pool
 }
@@ -16,59 +25,127 @@ func NewBranchDB(db *pgxpool.Pool) *BranchDB {
 	}
 }
 
-func (c *BranchDB) PutBranch(companyName, branchName string) error {
+// lockBranch reads a branch of companyName and locks it until tx ends, nil if there is none.
+func lockBranch(ctx context.Context, tx pgx.Tx, companyName, branchName string) (*Branch, error) {
 	query := `
-        INSERT INTO branch (branch_name, company_id)
-        VALUES ($1, (SELECT company_id FROM company WHERE company_name = $2))
-    `
-	_, err := c.db.Exec(context.Background(), query, branchName, companyName)
-	return err
-}
+		SELECT b.branch_id, b.branch_name, c.company_name, COALESCE(b.time_zone, ''), b.archived_at
+		FROM branch b JOIN company c ON b.company_id = c.company_id
+		WHERE c.company_name = $1 AND b.branch_name = $2
+		FOR UPDATE OF b
+	`
 
-func (c *BranchDB) DeleteBranchByName(companyName, branchName string) error {
-	sql := `
-        DELETE FROM branch
-        WHERE branch_name = $1 AND company_id = (
-            SELECT company_id
-            FROM company
-            WHERE company_name = $2
-        )
-    `
-
-	res, err := c.db.Exec(context.Background(), sql, branchName, companyName)
-	if err != nil {
-		return err
+	var branch Branch
+	err := tx.QueryRow(ctx, query, companyName, branchName).Scan(&branch.BranchID, &branch.BranchName, &branch.CompanyName, &branch.TimeZone, &branch.ArchivedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
 	}
-	if res.RowsAffected() == 0 {
-		return errors.New("no branch found with the specified name for the given company")
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	return &branch, nil
 }
 
-func (c *BranchDB) UpdateBranchByName(companyName, branchName, newBranchName string) error {
-	sql := `
-		UPDATE branch SET branch_name=$1 WHERE branch_name=$2 AND company_id = (
-		    SELECT company_id
-            FROM company
-            WHERE company_name = $3
-		)
-	`
+func (c *BranchDB) PutBranch(companyName, branchName, timeZone string, record audit.Recorder) error {
+	ctx := context.Background()
+	return pgx.BeginFunc(ctx, c.db, func(tx pgx.Tx) error {
+		query := `
+	        INSERT INTO branch (branch_name, company_id, time_zone)
+	        VALUES ($1, (SELECT company_id FROM company WHERE company_name = $2), NULLIF($3, ''))
+	    `
+		if _, err := tx.Exec(ctx, query, branchName, companyName, timeZone); err != nil {
+			return err
+		}
 
-	res, err := c.db.Exec(context.Background(), sql, newBranchName, branchName, companyName)
-	if err != nil {
-		return err
-	}
-	if res.RowsAffected() == 0 {
-		return errors.New("branchName does not exist")
-	}
-	return nil
+		after, err := lockBranch(ctx, tx, companyName, branchName)
+		if err != nil {
+			return err
+		}
+		return record(tx, strconv.Itoa(after.BranchID), nil, after)
+	})
+}
+
+func (c *BranchDB) DeleteBranchByName(companyName, branchName string, record audit.Recorder) error {
+	ctx := context.Background()
+	return pgx.BeginFunc(ctx, c.db, func(tx pgx.Tx) error {
+		before, err := lockBranch(ctx, tx, companyName, branchName)
+		if err != nil {
+			return err
+		}
+		if before == nil {
+			return ErrBranchNotFound
+		}
+
+		_, err = tx.Exec(ctx, `DELETE FROM branch WHERE branch_id = $1`, before.BranchID)
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+			return ErrBranchInUse
+		}
+		if err != nil {
+			return err
+		}
+		return record(tx, strconv.Itoa(before.BranchID), before, nil)
+	})
 }
 
-func (c *BranchDB) GetBranches() ([]Branch, error) {
-	query := "SELECT b.branch_id, b.branch_name, c.company_name FROM branch b JOIN company c ON b.company_id = c.company_id"
+// UpdateBranchByName renames a branch, its zone is only replaced when timeZone is not nil.
+func (c *BranchDB) UpdateBranchByName(companyName, branchName, newBranchName string, timeZone *string, record audit.Recorder) error {
+	ctx := context.Background()
+	return pgx.BeginFunc(ctx, c.db, func(tx pgx.Tx) error {
+		before, err := lockBranch(ctx, tx, companyName, branchName)
+		if err != nil {
+			return err
+		}
+		if before == nil {
+			return ErrBranchNotFound
+		}
+
+		sql := `UPDATE branch SET branch_name=$1, time_zone=CASE WHEN $3::text IS NULL THEN time_zone ELSE NULLIF($3, '') END WHERE branch_id=$2`
+		if _, err = tx.Exec(ctx, sql, newBranchName, before.BranchID, timeZone); err != nil {
+			return err
+		}
+
+		after, err := lockBranch(ctx, tx, companyName, newBranchName)
+		if err != nil {
+			return err
+		}
+		return record(tx, strconv.Itoa(before.BranchID), before, after)
+	})
+}
+
+// SetBranchArchived archives or restores a branch, the first archived_at is kept when archived twice.
+func (c *BranchDB) SetBranchArchived(companyName, branchName string, archived bool, record audit.Recorder) error {
+	ctx := context.Background()
+	return pgx.BeginFunc(ctx, c.db, func(tx pgx.Tx) error {
+		before, err := lockBranch(ctx, tx, companyName, branchName)
+		if err != nil {
+			return err
+		}
+		if before == nil {
+			return ErrBranchNotFound
+		}
+
+		sql := `UPDATE branch SET archived_at = CASE WHEN $2 THEN COALESCE(archived_at, now()) END WHERE branch_id = $1`
+		if _, err = tx.Exec(ctx, sql, before.BranchID, archived); err != nil {
+			return err
+		}
+
+		after, err := lockBranch(ctx, tx, companyName, branchName)
+		if err != nil {
+			return err
+		}
+		return record(tx, strconv.Itoa(before.BranchID), before, after)
+	})
+}
+
+func (c *BranchDB) GetBranches(companyName string, includeArchived bool) ([]Branch, error) {
+	query := `
+		SELECT b.branch_id, b.branch_name, c.company_name, COALESCE(b.time_zone, ''), b.archived_at
+		FROM branch b JOIN company c ON b.company_id = c.company_id
+		WHERE ($1 = '' OR c.company_name = $1) AND ($2 OR (b.archived_at IS NULL AND c.archived_at IS NULL))
+	`
 
 	var branches []Branch
-	rows, err := c.db.Query(context.Background(), query)
+	rows, err := c.db.Query(context.Background(), query, companyName, includeArchived)
 	if err != nil {
 		return nil, err
 	}
@@ -76,7 +153,7 @@ func (c *BranchDB) GetBranches() ([]Branch, error) {
 	defer rows.Close()
 	for rows.Next() {
 		var branch Branch
-		err := rows.Scan(&branch.BranchID, &branch.BranchName, &branch.CompanyName)
+		err := rows.Scan(&branch.BranchID, &branch.BranchName, &branch.CompanyName, &branch.TimeZone, &branch.ArchivedAt)
 		if err != nil {
 			return nil, err
 		}
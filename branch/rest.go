@@ -3,17 +3,24 @@ package branch
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"github.com/gorilla/mux"
 	"net/http"
+	"strconv"
+	"time"
+	"tzcnlr/audit"
+	"tzcnlr/auth"
 )
 
 type BranchAPI struct {
-	s *BranchService
+	s     *BranchService
+	audit *audit.AuditService
 }
 
-func NewBranchAPI(s *BranchService) *BranchAPI {
+func NewBranchAPI(s *BranchService, auditService *audit.AuditService) *BranchAPI {
 	return &BranchAPI{
-		s: s,
+		s:     s,
+		audit: auditService,
 	}
 }
 
@@ -31,13 +38,46 @@ func (api *BranchAPI) HandleUpdateBranchByName(w http.ResponseWriter, r *http.Re
 		return
 	}
 
-	branch, ok := r.Context().Value("branch").(Branch)
+	if _, err := auth.ScopeCompanyName(r.Context(), companyName); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	body, ok := r.Context().Value("body").([]byte)
 	if !ok {
-		http.Error(w, "error during json decode", http.StatusInternalServerError)
+		http.Error(w, "error accessing the body of the request", http.StatusInternalServerError)
+		return
+	}
+
+	if len(body) == 0 {
+		http.Error(w, "empty request body", http.StatusBadRequest)
+		return
+	}
+
+	// decoded apart from Branch, an absent timeZone must keep the zone instead of clearing it
+	var update BranchUpdate
+	if err := json.Unmarshal(body, &update); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if update.BranchName == "" {
+		http.Error(w, "branch name not provided in request body", http.StatusBadRequest)
 		return
 	}
 
-	err := api.s.UpdateBranchByName(companyName, currentName, branch)
+	if update.TimeZone != nil && *update.TimeZone != "" {
+		if _, err := time.LoadLocation(*update.TimeZone); err != nil {
+			http.Error(w, "invalid time zone: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	err := api.s.UpdateBranchByName(companyName, currentName, update, api.audit.Recorder(r.Context(), audit.ActionUpdate, audit.EntityBranch))
+	if errors.Is(err, ErrBranchNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		http.Error(w, "Error updating branch: "+err.Error(), http.StatusInternalServerError)
 		return
@@ -58,7 +98,20 @@ func (api *BranchAPI) HandleDeleteBranchByName(w http.ResponseWriter, r *http.Re
 		return
 	}
 
-	err := api.s.DeleteBranchByName(companyName, branchName)
+	if _, err := auth.ScopeCompanyName(r.Context(), companyName); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	err := api.s.DeleteBranchByName(companyName, branchName, api.audit.Recorder(r.Context(), audit.ActionDelete, audit.EntityBranch))
+	if errors.Is(err, ErrBranchNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, ErrBranchInUse) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -73,7 +126,55 @@ func (api *BranchAPI) HandlePostBranch(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err := api.s.PutBranch(branch)
+	if _, err := auth.ScopeCompanyName(r.Context(), branch.CompanyName); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	err := api.s.PutBranch(branch, api.audit.Recorder(r.Context(), audit.ActionCreate, audit.EntityBranch))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (api *BranchAPI) HandleArchiveBranchByName(w http.ResponseWriter, r *http.Request) {
+	api.setBranchArchived(w, r, true)
+}
+
+func (api *BranchAPI) HandleRestoreBranchByName(w http.ResponseWriter, r *http.Request) {
+	api.setBranchArchived(w, r, false)
+}
+
+func (api *BranchAPI) setBranchArchived(w http.ResponseWriter, r *http.Request, archived bool) {
+	vars := mux.Vars(r)
+	branchName := vars["branchName"]
+	companyName := vars["companyName"]
+
+	if branchName == "" {
+		http.Error(w, "branchName not set", http.StatusBadRequest)
+		return
+	}
+	if companyName == "" {
+		http.Error(w, "company name not provided in URL", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := auth.ScopeCompanyName(r.Context(), companyName); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	var err error
+	if archived {
+		err = api.s.ArchiveBranchByName(companyName, branchName, api.audit.Recorder(r.Context(), audit.ActionArchive, audit.EntityBranch))
+	} else {
+		err = api.s.RestoreBranchByName(companyName, branchName, api.audit.Recorder(r.Context(), audit.ActionRestore, audit.EntityBranch))
+	}
+	if errors.Is(err, ErrBranchNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -81,7 +182,22 @@ func (api *BranchAPI) HandlePostBranch(w http.ResponseWriter, r *http.Request) {
 }
 
 func (api *BranchAPI) HandleGetBranch(w http.ResponseWriter, r *http.Request) {
-	result, err := api.s.GetBranches()
+	query := r.URL.Query()
+	companyName, err := auth.ScopeCompanyName(r.Context(), query.Get("companyName"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	includeArchived := false
+	if value := query.Get("includeArchived"); value != "" {
+		if includeArchived, err = strconv.ParseBool(value); err != nil {
+			http.Error(w, "invalid includeArchived: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	result, err := api.s.GetBranches(companyName, includeArchived)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -128,6 +244,13 @@ func (api *BranchAPI) DecodeBranchBodyHandler(next http.Handler) http.Handler {
 			return
 		}
 
+		if branch.TimeZone != "" {
+			if _, err := time.LoadLocation(branch.TimeZone); err != nil {
+				http.Error(w, "invalid time zone: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
 		ctx := context.WithValue(r.Context(), "branch", branch)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
@@ -3,8 +3,6 @@ package main
 import (
 	"bytes"
 	"context"
-	"crypto/rand"
-	"encoding/base64"
 	"fmt"
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
@@ -14,61 +12,97 @@ import (
 	"log"
 	"net/http"
 	"os"
-	"strings"
+	"strconv"
+	"time"
+	"tzcnlr/apikey"
+	"tzcnlr/audit"
 	"tzcnlr/auth"
 	"tzcnlr/branch"
 	"tzcnlr/company"
 	"tzcnlr/completedtask"
+	"tzcnlr/invoice"
 	"tzcnlr/machine"
+	"tzcnlr/migrate"
+	"tzcnlr/ratecard"
+	"tzcnlr/report"
+	"tzcnlr/user"
 )
 
-func generateSecretKey() (string, error) {
-	key := make([]byte, 32)
-	if _, err := rand.Read(key); err != nil {
-		return "", err
+// loadKeyRing reads the jwt keys from JWT_KEYS_FILE, JWT_KEYS or the legacy JWT_KEY, in that order.
+// Tokens signed with JWT_KEY before key ids existed stay valid in a key ring only as long as it
+// lists that secret under the "default" key id.
+func loadKeyRing() (*auth.KeyRing, error) {
+	keys := os.Getenv("JWT_KEYS")
+	if keysFile := os.Getenv("JWT_KEYS_FILE"); keysFile != "" {
+		content, err := os.ReadFile(keysFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading jwt keys file: %w", err)
+		}
+		keys = string(content)
 	}
-	return base64.URLEncoding.EncodeToString(key), nil
+	if keys == "" {
+		// JWT_KEY is a bare secret, a colon in it is not a key id separator
+		return auth.NewLegacyKeyRing([]byte(os.Getenv("JWT_KEY")))
+	}
+	return auth.ParseKeyRing(keys, os.Getenv("JWT_SIGNING_KEY_ID"))
 }
 
-func executeMigrationSchema(filePath string, conn *pgxpool.Pool) error {
-	completeQuery, err := os.ReadFile(filePath)
-	if err != nil {
-		return fmt.Errorf("error reading migration file: %w", err)
+// migrationsDir holds the numbered up and down scripts of the schema.
+const migrationsDir = "./mig"
+
+// runMigrateCommand implements "main migrate up|down [steps]|status".
+func runMigrateCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: migrate up|down [steps]|status")
 	}
 
-	queries := strings.Split(string(completeQuery), ";")
+	conn, err := pgxpool.New(context.Background(), os.Getenv("DATABASE_URL"))
+	if err != nil {
+		return fmt.Errorf("unable to connect to database: %w", err)
+	}
+	defer conn.Close()
 
-	ctx := context.Background()
-	tx, err := conn.Begin(ctx)
+	migrator, err := migrate.NewMigrator(conn, migrationsDir)
 	if err != nil {
-		return fmt.Errorf("error starting transaction: %w", err)
+		return err
 	}
 
-	defer func() {
-		// Attempt to roll back the transaction if there's an error
-		if err != nil {
-			if rerr := tx.Rollback(ctx); rerr != nil {
-				fmt.Printf("error rolling back transaction: %v\n", rerr)
+	ctx := context.Background()
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		for _, m := range applied {
+			fmt.Printf("applied %06d_%s\n", m.Version, m.Name)
+		}
+		return err
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				return fmt.Errorf("steps must be a positive number")
 			}
 		}
-	}()
-
-	for _, query := range queries {
-		query = strings.TrimSpace(query)
-		if query == "" {
-			continue
+		reverted, err := migrator.Down(ctx, steps)
+		for _, m := range reverted {
+			fmt.Printf("reverted %06d_%s\n", m.Version, m.Name)
 		}
-
-		if _, err = tx.Exec(ctx, query); err != nil {
-			return fmt.Errorf("error executing query '%s': %w", query, err)
+		return err
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
 		}
+		for _, status := range statuses {
+			appliedAt := "pending"
+			if status.AppliedAt != nil {
+				appliedAt = status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%06d_%-30s %s\n", status.Version, status.Name, appliedAt)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown migrate command %q, expected up, down or status", args[0])
 	}
-
-	if err = tx.Commit(ctx); err != nil {
-		return fmt.Errorf("error committing transaction: %w", err)
-	}
-
-	return nil
 }
 
 func DrainAndCloseRequestBody(next http.Handler) http.Handler {
@@ -136,21 +170,37 @@ func ErrorLoggingMiddleware(next http.Handler) http.Handler {
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrateCommand(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "migrate: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
 
-	jwtSecretKey, err := generateSecretKey()
+	keyRing, err := loadKeyRing()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, err.Error())
+		fmt.Fprintf(os.Stderr, "Unable to load jwt keys: %v\n", err)
 		os.Exit(1)
 	}
-	fmt.Println(jwtSecretKey)
 
-	jwtKey := os.Getenv("JWT_KEY")
 	databaseUrl := os.Getenv("DATABASE_URL")
 	frontendURL := os.Getenv("FRONTEND_URL")
 	password := os.Getenv("PASSWORD")
 	port := os.Getenv("PORT_NUMBER")
 	host := os.Getenv("HOST_ADDR")
 
+	// TIME_ZONE is the zone of tasks at branches and companies without a zone of their own
+	timeZone := os.Getenv("TIME_ZONE")
+	if timeZone == "" {
+		timeZone = "Europe/Istanbul"
+	}
+	location, err := time.LoadLocation(timeZone)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to load TIME_ZONE: %v\n", err)
+		os.Exit(1)
+	}
+
 	fmt.Println("CORS FRONTEND--", frontendURL, "CORS FRONTEND")
 	fmt.Println("PORT--", port, "--PORT")
 	fmt.Println("HOST--", host, "--HOST")
@@ -162,29 +212,101 @@ func main() {
 	}
 	defer conn.Close()
 
-	err = executeMigrationSchema("./mig/000000.sql", conn)
+	// pending migrations are applied on startup, replicas wait for each other on the migration lock
+	migrator, err := migrate.NewMigrator(conn, migrationsDir)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, err.Error())
+		fmt.Fprintf(os.Stderr, "Unable to load migrations: %v\n", err)
+		os.Exit(1)
+	}
+	if _, err = migrator.Up(context.Background()); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to migrate database: %v\n", err)
 		os.Exit(1)
 	}
 
+	auditDB := audit.NewAuditDB(conn)
+	auditService := audit.NewAuditService(auditDB)
+	auditAPI := audit.NewAuditAPI(auditService)
+
 	completedTaskDB := completedtask.NewCompletedTaskDB(conn)
-	completedTaskService := completedtask.NewCompletedTaskService(completedTaskDB)
-	completedTaskApi := completedtask.NewCompletedTaskAPI(completedTaskService)
+	completedTaskService := completedtask.NewCompletedTaskService(completedTaskDB, location)
+	completedTaskApi := completedtask.NewCompletedTaskAPI(completedTaskService, auditService)
 
 	companyDB := company.NewCompanyDB(conn)
 	companyService := company.NewCompanyService(companyDB)
-	companyAPI := company.NewCompanyAPI(companyService)
+	companyAPI := company.NewCompanyAPI(companyService, auditService)
 
 	machineDB := machine.NewMachineDB(conn)
 	machineService := machine.NewMachineService(machineDB)
-	machineAPI := machine.NewMachineAPI(machineService)
+	machineAPI := machine.NewMachineAPI(machineService, auditService)
 
 	branchDB := branch.NewBranchDB(conn)
 	branchService := branch.NewBranchService(branchDB)
-	branchAPI := branch.NewBranchAPI(branchService)
+	branchAPI := branch.NewBranchAPI(branchService, auditService)
+
+	rateCardDB := ratecard.NewRateCardDB(conn)
+	rateCardService := ratecard.NewRateCardService(rateCardDB)
+	rateCardAPI := ratecard.NewRateCardAPI(rateCardService)
+
+	invoiceDB := invoice.NewInvoiceDB(conn)
+	invoiceService := invoice.NewInvoiceService(invoiceDB, location)
+	invoiceAPI := invoice.NewInvoiceAPI(invoiceService)
+
+	reportDB := report.NewReportDB(conn)
+	reportService := report.NewReportService(reportDB)
+	reportAPI := report.NewReportAPI(reportService, location)
+
+	userDB := user.NewUserDB(conn)
+	userService := user.NewUserService(userDB)
+	userAPI := user.NewUserAPI(userService)
+
+	// PASSWORD only seeds the first admin of an empty users table
+	if password != "" {
+		err = userService.CreateInitialUser(user.User{Username: "admin", Password: password, Role: user.RoleAdmin})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to create initial user: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	authDB := auth.NewAuthDB(conn)
+	if err = authDB.DeleteExpiredTokens(); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to clean up expired tokens: %v\n", err)
+	}
+	apiKeyDB := apikey.NewAPIKeyDB(conn)
+	apiKeyService := apikey.NewAPIKeyService(apiKeyDB)
+	apiKeyAPI := apikey.NewAPIKeyAPI(apiKeyService)
 
-	authAPI := auth.NewAuthAPI("admin", password, []byte(jwtKey))
+	// TRUSTED_PROXIES lists the reverse proxies in front of the service, logins relayed by them
+	// are throttled by the client address they forward instead of their own
+	trustedProxies, err := auth.ParseTrustedProxies(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to parse TRUSTED_PROXIES: %v\n", err)
+		os.Exit(1)
+	}
+
+	authAPI := auth.NewAuthAPI(userService, apiKeyService, authDB, keyRing, trustedProxies)
+
+	var oidcAPI *auth.OIDCAPI
+	if issuer := os.Getenv("OIDC_ISSUER"); issuer != "" {
+		roleMapping, err := auth.ParseRoleMapping(os.Getenv("OIDC_ROLE_MAPPING"))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to parse OIDC_ROLE_MAPPING: %v\n", err)
+			os.Exit(1)
+		}
+
+		oidcProvider := auth.NewOIDCProvider(auth.OIDCConfig{
+			Issuer:              issuer,
+			ClientID:            os.Getenv("OIDC_CLIENT_ID"),
+			ClientSecret:        os.Getenv("OIDC_CLIENT_SECRET"),
+			RedirectURL:         os.Getenv("OIDC_REDIRECT_URL"),
+			UsernameClaim:       os.Getenv("OIDC_USERNAME_CLAIM"),
+			RoleClaim:           os.Getenv("OIDC_ROLE_CLAIM"),
+			RoleMapping:         roleMapping,
+			AutoCreateUsers:     os.Getenv("OIDC_AUTO_CREATE_USERS") == "true",
+			FrontendRedirectURL: os.Getenv("OIDC_FRONTEND_REDIRECT_URL"),
+		}, &http.Client{Timeout: 10 * time.Second})
+		oidcAPI = auth.NewOIDCAPI(authAPI, oidcProvider)
+	}
 
 	limiter := rate.NewLimiter(100, 200)
 	r := mux.NewRouter()
@@ -193,33 +315,91 @@ func main() {
 
 	corsOptions := handlers.CORS(
 		handlers.AllowedOrigins([]string{frontendURL}),
-		handlers.AllowedMethods([]string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}),
-		handlers.AllowedHeaders([]string{"Content-Type", "X-Requested-With", "Accept", "Content-Type", "Content-Length", "Accept-Encoding", "X-CSRF-Token", "Authorization"}),
+		handlers.AllowedMethods([]string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}),
+		handlers.AllowedHeaders([]string{"Content-Type", "X-Requested-With", "Accept", "Content-Type", "Content-Length", "Accept-Encoding", "X-CSRF-Token", "Authorization", "X-API-Key"}),
+		handlers.ExposedHeaders([]string{"X-Total-Count", "X-Next-Cursor"}),
 	)
 
 	r.Handle("/login", authAPI.DecodeCredentialsBodyHandler(http.HandlerFunc(authAPI.LoginHandler))).Methods("POST")
+	r.HandleFunc("/refresh", authAPI.RefreshHandler).Methods("POST")
+	if oidcAPI != nil {
+		r.HandleFunc("/oidc/login", oidcAPI.HandleLogin).Methods("GET")
+		r.HandleFunc("/oidc/callback", oidcAPI.HandleCallback).Methods("GET")
+	}
+	r.Handle("/logout", authAPI.ValidateTokenMiddleware(http.HandlerFunc(authAPI.LogoutHandler))).Methods("POST")
 
 	apiRouter := r.PathPrefix("/api").Subrouter()
 	apiRouter.Use(ErrorLoggingMiddleware)
 	apiRouter.Use(authAPI.ValidateTokenMiddleware)
 
-	apiRouter.HandleFunc("/completedTasks", completedTaskApi.HandlePostCompletedTask).Methods(http.MethodPost)
-	apiRouter.HandleFunc("/completedTasks", completedTaskApi.HandleGetCompletedTask).Methods(http.MethodGet)
+	// viewers only reach the GET routes, deleting is left to admins
+	editorsOnly := authAPI.RequireRole(user.RoleAdmin, user.RoleDispatcher)
+	adminsOnly := authAPI.RequireRole(user.RoleAdmin)
 
-	apiRouter.Handle("/companies", companyAPI.DecodeCompanyBodyHandler(http.HandlerFunc(companyAPI.HandlePostCompany))).Methods(http.MethodPost)
-	apiRouter.Handle("/companies/{companyName}", companyAPI.DecodeCompanyBodyHandler(http.HandlerFunc(companyAPI.HandleUpdateCompanyByName))).Methods(http.MethodPut)
+	apiRouter.Handle("/completedTasks", editorsOnly(http.HandlerFunc(completedTaskApi.HandlePostCompletedTask))).Methods(http.MethodPost)
+	apiRouter.HandleFunc("/completedTasks", completedTaskApi.HandleGetCompletedTask).Methods(http.MethodGet)
+	apiRouter.Handle("/completedTasks/import", editorsOnly(http.HandlerFunc(completedTaskApi.HandleImportCompletedTasks))).Methods(http.MethodPost)
+	apiRouter.HandleFunc("/completedTasks/export", completedTaskApi.HandleExportCompletedTasks).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/completedTasks/{id:[0-9]+}", completedTaskApi.HandleGetCompletedTaskByID).Methods(http.MethodGet)
+	apiRouter.Handle("/completedTasks/{id:[0-9]+}", editorsOnly(http.HandlerFunc(completedTaskApi.HandleUpdateCompletedTask))).Methods(http.MethodPut)
+	apiRouter.Handle("/completedTasks/{id:[0-9]+}", editorsOnly(http.HandlerFunc(completedTaskApi.HandlePatchCompletedTask))).Methods(http.MethodPatch)
+	apiRouter.Handle("/completedTasks/{id:[0-9]+}", adminsOnly(http.HandlerFunc(completedTaskApi.HandleDeleteCompletedTask))).Methods(http.MethodDelete)
+
+	apiRouter.Handle("/companies", editorsOnly(companyAPI.DecodeCompanyBodyHandler(http.HandlerFunc(companyAPI.HandlePostCompany)))).Methods(http.MethodPost)
+	// a rename would strand the tokens of the users bound to the company and a new zone re-zones
+	// its task history, so company bound users can not change it
+	apiRouter.Handle("/companies/{companyName}", editorsOnly(authAPI.RequireUnscoped(http.HandlerFunc(companyAPI.HandleUpdateCompanyByName)))).Methods(http.MethodPut)
 	apiRouter.HandleFunc("/companies", companyAPI.HandleGetCompanies).Methods(http.MethodGet)
-	apiRouter.HandleFunc("/companies/{companyName}", companyAPI.HandleDeleteCompanyByName).Methods(http.MethodDelete)
+	apiRouter.Handle("/companies/{companyName}", adminsOnly(http.HandlerFunc(companyAPI.HandleDeleteCompanyByName))).Methods(http.MethodDelete)
+	apiRouter.Handle("/companies/{companyName}/archive", adminsOnly(http.HandlerFunc(companyAPI.HandleArchiveCompanyByName))).Methods(http.MethodPost)
+	apiRouter.Handle("/companies/{companyName}/restore", adminsOnly(http.HandlerFunc(companyAPI.HandleRestoreCompanyByName))).Methods(http.MethodPost)
 
-	apiRouter.Handle("/machines", machineAPI.DecodeMachineBodyHandler(http.HandlerFunc(machineAPI.HandlePostMachine))).Methods(http.MethodPost)
-	apiRouter.Handle("/machines/{machineName}", machineAPI.DecodeMachineBodyHandler(http.HandlerFunc(machineAPI.HandleUpdateMachineByName))).Methods(http.MethodPut)
+	// machines are shared by every company, so company bound users can not change them
+	apiRouter.Handle("/machines", editorsOnly(authAPI.RequireUnscoped(machineAPI.DecodeMachineBodyHandler(http.HandlerFunc(machineAPI.HandlePostMachine))))).Methods(http.MethodPost)
+	apiRouter.Handle("/machines/{machineName}", editorsOnly(authAPI.RequireUnscoped(machineAPI.DecodeMachineBodyHandler(http.HandlerFunc(machineAPI.HandleUpdateMachineByName))))).Methods(http.MethodPut)
 	apiRouter.HandleFunc("/machines", machineAPI.HandleGetMachines).Methods(http.MethodGet)
-	apiRouter.HandleFunc("/machines/{machineName}", machineAPI.HandleDeleteMachineByName).Methods(http.MethodDelete)
+	apiRouter.Handle("/machines/{machineName}", adminsOnly(authAPI.RequireUnscoped(http.HandlerFunc(machineAPI.HandleDeleteMachineByName)))).Methods(http.MethodDelete)
+	apiRouter.Handle("/machines/{machineName}/archive", adminsOnly(authAPI.RequireUnscoped(http.HandlerFunc(machineAPI.HandleArchiveMachineByName)))).Methods(http.MethodPost)
+	apiRouter.Handle("/machines/{machineName}/restore", adminsOnly(authAPI.RequireUnscoped(http.HandlerFunc(machineAPI.HandleRestoreMachineByName)))).Methods(http.MethodPost)
 
-	apiRouter.Handle("/branches", branchAPI.DecodeBranchBodyHandler(http.HandlerFunc(branchAPI.HandlePostBranch))).Methods(http.MethodPost)
-	apiRouter.Handle("/branches/{companyName}/{branchName}", branchAPI.DecodeBranchBodyHandler(http.HandlerFunc(branchAPI.HandleUpdateBranchByName))).Methods(http.MethodPut)
+	apiRouter.Handle("/branches", editorsOnly(branchAPI.DecodeBranchBodyHandler(http.HandlerFunc(branchAPI.HandlePostBranch)))).Methods(http.MethodPost)
+	// nor re-zone the branches of their company
+	apiRouter.Handle("/branches/{companyName}/{branchName}", editorsOnly(authAPI.RequireUnscoped(http.HandlerFunc(branchAPI.HandleUpdateBranchByName)))).Methods(http.MethodPut)
 	apiRouter.HandleFunc("/branches", branchAPI.HandleGetBranch).Methods(http.MethodGet)
-	apiRouter.HandleFunc("/branches/{companyName}/{branchName}", branchAPI.HandleDeleteBranchByName).Methods(http.MethodDelete)
+	apiRouter.Handle("/branches/{companyName}/{branchName}", adminsOnly(http.HandlerFunc(branchAPI.HandleDeleteBranchByName))).Methods(http.MethodDelete)
+	apiRouter.Handle("/branches/{companyName}/{branchName}/archive", adminsOnly(http.HandlerFunc(branchAPI.HandleArchiveBranchByName))).Methods(http.MethodPost)
+	apiRouter.Handle("/branches/{companyName}/{branchName}/restore", adminsOnly(http.HandlerFunc(branchAPI.HandleRestoreBranchByName))).Methods(http.MethodPost)
+
+	// prices are set for all companies at once, company bound users only read their own
+	apiRouter.Handle("/rateCards", adminsOnly(authAPI.RequireUnscoped(rateCardAPI.DecodeRateCardBodyHandler(http.HandlerFunc(rateCardAPI.HandlePostRateCard))))).Methods(http.MethodPost)
+	apiRouter.HandleFunc("/rateCards", rateCardAPI.HandleGetRateCards).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/rateCards/{id:[0-9]+}", rateCardAPI.HandleGetRateCardByID).Methods(http.MethodGet)
+	apiRouter.Handle("/rateCards/{id:[0-9]+}", adminsOnly(authAPI.RequireUnscoped(rateCardAPI.DecodeRateCardBodyHandler(http.HandlerFunc(rateCardAPI.HandleUpdateRateCard))))).Methods(http.MethodPut)
+	apiRouter.Handle("/rateCards/{id:[0-9]+}", adminsOnly(authAPI.RequireUnscoped(http.HandlerFunc(rateCardAPI.HandleDeleteRateCard)))).Methods(http.MethodDelete)
+
+	// invoices are issued by the operator, company bound users can only read theirs
+	apiRouter.Handle("/invoices", adminsOnly(authAPI.RequireUnscoped(http.HandlerFunc(invoiceAPI.HandlePostInvoice)))).Methods(http.MethodPost)
+	apiRouter.HandleFunc("/invoices", invoiceAPI.HandleGetInvoices).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/invoices/{id:[0-9]+}", invoiceAPI.HandleGetInvoiceByID).Methods(http.MethodGet)
+
+	apiRouter.HandleFunc("/reports/utilization", reportAPI.HandleGetUtilization).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/reports/companies", reportAPI.HandleGetCompanySummaries).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/reports/branches", reportAPI.HandleGetBranchSummaries).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/reports/timeseries", reportAPI.HandleGetTimeSeries).Methods(http.MethodGet)
+
+	apiRouter.Handle("/users", adminsOnly(userAPI.DecodeUserBodyHandler(http.HandlerFunc(userAPI.HandlePostUser)))).Methods(http.MethodPost)
+	apiRouter.Handle("/users/{username}", adminsOnly(http.HandlerFunc(userAPI.HandleUpdateUserByName))).Methods(http.MethodPut)
+	apiRouter.Handle("/users", adminsOnly(http.HandlerFunc(userAPI.HandleGetUsers))).Methods(http.MethodGet)
+	apiRouter.Handle("/users/{username}", adminsOnly(http.HandlerFunc(userAPI.HandleDeleteUserByName))).Methods(http.MethodDelete)
+
+	apiRouter.Handle("/apiKeys", adminsOnly(http.HandlerFunc(apiKeyAPI.HandlePostAPIKey))).Methods(http.MethodPost)
+	apiRouter.Handle("/apiKeys", adminsOnly(http.HandlerFunc(apiKeyAPI.HandleGetAPIKeys))).Methods(http.MethodGet)
+	apiRouter.Handle("/apiKeys/{apiKeyName}", adminsOnly(http.HandlerFunc(apiKeyAPI.HandleRevokeAPIKeyByName))).Methods(http.MethodDelete)
+
+	apiRouter.Handle("/loginAttempts", adminsOnly(http.HandlerFunc(authAPI.HandleGetLoginAttempts))).Methods(http.MethodGet)
+
+	// the audit log spans every company, so it is kept from any caller bound to a company
+	apiRouter.Handle("/audit", adminsOnly(authAPI.RequireUnscoped(http.HandlerFunc(auditAPI.HandleGetAudit)))).Methods(http.MethodGet)
 
 	err = http.ListenAndServe(host+":"+port, corsOptions(r))
 	if err != nil {
@@ -0,0 +1,39 @@
+// stubidp is a minimal OpenID Connect provider for trying the oidc login locally.
+// It signs in everyone who reaches /authorize without asking, never run it anywhere else.
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"tzcnlr/stubidp"
+)
+
+func getenv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func main() {
+	addr := getenv("STUB_IDP_ADDR", "localhost:9000")
+	issuer := getenv("STUB_IDP_ISSUER", "http://"+addr)
+
+	idp, err := stubidp.New(stubidp.Config{
+		Issuer:       issuer,
+		ClientID:     getenv("STUB_IDP_CLIENT_ID", "tzcnlr"),
+		ClientSecret: getenv("STUB_IDP_CLIENT_SECRET", "secret"),
+		Username:     getenv("STUB_IDP_USERNAME", "operator"),
+		Roles:        strings.Split(getenv("STUB_IDP_ROLES", "dispatcher"), ","),
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to generate signing key: %v\n", err)
+		os.Exit(1)
+	}
+
+	log.Printf("stub identity provider %s listening on %s\n", issuer, addr)
+	log.Fatal(http.ListenAndServe(addr, idp.Handler()))
+}
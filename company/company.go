@@ -1,8 +1,24 @@
 package company
 
+import (
+	"time"
+	"tzcnlr/audit"
+)
+
 type Company struct {
 	CompanyID   int    `json:"id"`
 	CompanyName string `json:"companyName"`
+	// TimeZone is the zone tasks of the company are recorded in, empty for the deployment's zone.
+	TimeZone string `json:"timeZone,omitempty"`
+	// ArchivedAt is set for companies that are no longer served, their branches go with them.
+	ArchivedAt *time.Time `json:"archivedAt,omitempty"`
+}
+
+// CompanyUpdate is the body of a company update. A nil TimeZone keeps the zone of the company,
+// an empty one clears it.
+type CompanyUpdate struct {
+	CompanyName string  `json:"companyName"`
+	TimeZone    *string `json:"timeZone"`
 }
 
 type CompanyService struct {
@@ -15,22 +31,47 @@ func NewCompanyService(cDB *CompanyDB) *CompanyService {
 	}
 }
 
-func (s *CompanyService) PutCompany(company Company) error {
-	err := s.cDB.PutCompany(company.CompanyName)
+// PutCompany stores a new company, record writes its audit entry in the same transaction. The
+// other changes take a Recorder the same way.
+func (s *CompanyService) PutCompany(company Company, record audit.Recorder) error {
+	err := s.cDB.PutCompany(company.CompanyName, company.TimeZone, record)
 	return err
 }
 
-func (s *CompanyService) DeleteCompanyByName(companyName string) error {
-	err := s.cDB.DeleteByName(companyName)
+func (s *CompanyService) DeleteCompanyByName(companyName string, record audit.Recorder) error {
+	err := s.cDB.DeleteByName(companyName, record)
 	return err
 }
 
-func (s *CompanyService) UpdateCompanyByName(companyName string, newCompany Company) error {
-	err := s.cDB.UpdateCompanyByName(companyName, newCompany.CompanyName)
+func (s *CompanyService) UpdateCompanyByName(companyName string, update CompanyUpdate, record audit.Recorder) error {
+	err := s.cDB.UpdateCompanyByName(companyName, update.CompanyName, update.TimeZone, record)
 	return err
 }
 
-func (s *CompanyService) GetCompanies() ([]Company, error) {
-	result, err := s.cDB.GetCompanies()
+// ArchiveCompanyByName stops new tasks for the company and its branches, past tasks still show them.
+func (s *CompanyService) ArchiveCompanyByName(companyName string, record audit.Recorder) error {
+	return s.cDB.SetCompanyArchived(companyName, true, record)
+}
+
+func (s *CompanyService) RestoreCompanyByName(companyName string, record audit.Recorder) error {
+	return s.cDB.SetCompanyArchived(companyName, false, record)
+}
+
+// GetCompanyByName returns a company whether it is archived or not.
+func (s *CompanyService) GetCompanyByName(companyName string) (Company, error) {
+	companies, err := s.cDB.GetCompanies(companyName, true)
+	if err != nil {
+		return Company{}, err
+	}
+	if companyName == "" || len(companies) == 0 {
+		return Company{}, ErrCompanyNotFound
+	}
+	return companies[0], nil
+}
+
+// GetCompanies returns every active company, or only companyName if it is set. Archived
+// companies are only included with includeArchived.
+func (s *CompanyService) GetCompanies(companyName string, includeArchived bool) ([]Company, error) {
+	result, err := s.cDB.GetCompanies(companyName, includeArchived)
 	return result, err
 }
@@ -3,9 +3,18 @@ package company
 import (
 	"context"
 	"errors"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"strconv"
+	"tzcnlr/audit"
 )
 
+// ErrCompanyInUse is returned when a company, or one of its branches, still has completed tasks.
+var ErrCompanyInUse = errors.New("company has completed tasks and cannot be deleted, archive it instead")
+
+var ErrCompanyNotFound = errors.New("companyName does not exist")
+
 type CompanyDB struct {
 	db *pgxpool.Pool
 }
@@ -16,50 +25,123 @@ func NewCompanyDB(db *pgxpool.Pool) *CompanyDB {
 	}
 }
 
-func (c *CompanyDB) PutCompany(companyName string) error {
-	query := "insert into company (company_name) values ($1)"
-	_, err := c.db.Exec(context.Background(), query, companyName)
-	return err
-}
-
-func (c *CompanyDB) DeleteByName(companyName string) error {
-	sql := `DELETE FROM company WHERE company_name=$1`
+// lockCompany reads a company and locks it until tx ends, nil if it does not exist.
+func lockCompany(ctx context.Context, tx pgx.Tx, companyName string) (*Company, error) {
+	query := "select company_id, company_name, coalesce(time_zone, ''), archived_at from company where company_name = $1 for update"
 
-	res, err := c.db.Exec(context.Background(), sql, companyName)
-	if err != nil {
-		return err
+	var company Company
+	err := tx.QueryRow(ctx, query, companyName).Scan(&company.CompanyID, &company.CompanyName, &company.TimeZone, &company.ArchivedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
 	}
-	if res.RowsAffected() == 0 {
-		return errors.New("companyName does not exist")
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	return &company, nil
 }
 
-func (c *CompanyDB) UpdateCompanyByName(companyName, newCompanyName string) error {
-	sql := `UPDATE company SET company_name=$1 WHERE company_name=$2`
+func (c *CompanyDB) PutCompany(companyName, timeZone string, record audit.Recorder) error {
+	ctx := context.Background()
+	return pgx.BeginFunc(ctx, c.db, func(tx pgx.Tx) error {
+		query := "insert into company (company_name, time_zone) values ($1, NULLIF($2, ''))"
+		if _, err := tx.Exec(ctx, query, companyName, timeZone); err != nil {
+			return err
+		}
 
-	res, err := c.db.Exec(context.Background(), sql, newCompanyName, companyName)
-	if err != nil {
-		return err
-	}
-	if res.RowsAffected() == 0 {
-		return errors.New("companyName does not exist")
-	}
-	return nil
+		after, err := lockCompany(ctx, tx, companyName)
+		if err != nil {
+			return err
+		}
+		return record(tx, strconv.Itoa(after.CompanyID), nil, after)
+	})
+}
+
+func (c *CompanyDB) DeleteByName(companyName string, record audit.Recorder) error {
+	ctx := context.Background()
+	return pgx.BeginFunc(ctx, c.db, func(tx pgx.Tx) error {
+		before, err := lockCompany(ctx, tx, companyName)
+		if err != nil {
+			return err
+		}
+		if before == nil {
+			return ErrCompanyNotFound
+		}
+
+		sql := `DELETE FROM company WHERE company_id=$1`
+		_, err = tx.Exec(ctx, sql, before.CompanyID)
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+			return ErrCompanyInUse
+		}
+		if err != nil {
+			return err
+		}
+		return record(tx, strconv.Itoa(before.CompanyID), before, nil)
+	})
+}
+
+// UpdateCompanyByName renames a company, its zone is only replaced when timeZone is not nil.
+func (c *CompanyDB) UpdateCompanyByName(companyName, newCompanyName string, timeZone *string, record audit.Recorder) error {
+	ctx := context.Background()
+	return pgx.BeginFunc(ctx, c.db, func(tx pgx.Tx) error {
+		before, err := lockCompany(ctx, tx, companyName)
+		if err != nil {
+			return err
+		}
+		if before == nil {
+			return ErrCompanyNotFound
+		}
+
+		sql := `UPDATE company SET company_name=$1, time_zone=CASE WHEN $3::text IS NULL THEN time_zone ELSE NULLIF($3, '') END WHERE company_id=$2`
+		if _, err = tx.Exec(ctx, sql, newCompanyName, before.CompanyID, timeZone); err != nil {
+			return err
+		}
+
+		after, err := lockCompany(ctx, tx, newCompanyName)
+		if err != nil {
+			return err
+		}
+		return record(tx, strconv.Itoa(before.CompanyID), before, after)
+	})
+}
+
+// SetCompanyArchived archives or restores a company, archiving again keeps the first archived_at.
+func (c *CompanyDB) SetCompanyArchived(companyName string, archived bool, record audit.Recorder) error {
+	ctx := context.Background()
+	return pgx.BeginFunc(ctx, c.db, func(tx pgx.Tx) error {
+		before, err := lockCompany(ctx, tx, companyName)
+		if err != nil {
+			return err
+		}
+		if before == nil {
+			return ErrCompanyNotFound
+		}
+
+		sql := `UPDATE company SET archived_at = CASE WHEN $2 THEN COALESCE(archived_at, now()) END WHERE company_id=$1`
+		if _, err = tx.Exec(ctx, sql, before.CompanyID, archived); err != nil {
+			return err
+		}
+
+		after, err := lockCompany(ctx, tx, companyName)
+		if err != nil {
+			return err
+		}
+		return record(tx, strconv.Itoa(before.CompanyID), before, after)
+	})
 }
 
-func (c *CompanyDB) GetCompanies() ([]Company, error) {
+func (c *CompanyDB) GetCompanies(companyName string, includeArchived bool) ([]Company, error) {
 
-	query := "select company_id, company_name from company"
+	query := "select company_id, company_name, coalesce(time_zone, ''), archived_at from company where ($1 = '' or company_name = $1) and ($2 or archived_at is null)"
 	var companies []Company
-	rows, err := c.db.Query(context.Background(), query)
+	rows, err := c.db.Query(context.Background(), query, companyName, includeArchived)
 	if err != nil {
 		return nil, err
 	}
 
 	for rows.Next() {
 		var company Company
-		err := rows.Scan(&company.CompanyID, &company.CompanyName)
+		err := rows.Scan(&company.CompanyID, &company.CompanyName, &company.TimeZone, &company.ArchivedAt)
 
 		if err != nil {
 			return nil, err
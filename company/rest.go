@@ -6,15 +6,21 @@ import (
 	"errors"
 	"github.com/gorilla/mux"
 	"net/http"
+	"strconv"
+	"time"
+	"tzcnlr/audit"
+	"tzcnlr/auth"
 )
 
 type CompanyAPI struct {
-	s *CompanyService
+	s     *CompanyService
+	audit *audit.AuditService
 }
 
-func NewCompanyAPI(s *CompanyService) *CompanyAPI {
+func NewCompanyAPI(s *CompanyService, auditService *audit.AuditService) *CompanyAPI {
 	return &CompanyAPI{
-		s: s,
+		s:     s,
+		audit: auditService,
 	}
 }
 
@@ -27,14 +33,49 @@ func (api *CompanyAPI) HandleUpdateCompanyByName(w http.ResponseWriter, r *http.
 		return
 	}
 
-	company, ok := r.Context().Value("company").(Company)
+	if _, err := auth.ScopeCompanyName(r.Context(), currentName); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	body, ok := r.Context().Value("body").([]byte)
 	if !ok {
-		err := errors.New("error during json decode")
+		err := errors.New("error accessing the body of the request")
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	err := api.s.UpdateCompanyByName(currentName, company)
+	if len(body) == 0 {
+		err := errors.New("empty request body")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// decoded apart from Company, an absent timeZone must keep the zone instead of clearing it
+	var update CompanyUpdate
+	if err := json.Unmarshal(body, &update); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if update.CompanyName == "" {
+		err := errors.New("company name not provided in request body")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if update.TimeZone != nil && *update.TimeZone != "" {
+		if _, err := time.LoadLocation(*update.TimeZone); err != nil {
+			http.Error(w, "invalid time zone: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	err := api.s.UpdateCompanyByName(currentName, update, api.audit.Recorder(r.Context(), audit.ActionUpdate, audit.EntityCompany))
+	if errors.Is(err, ErrCompanyNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		http.Error(w, "Error updating company: "+err.Error(), http.StatusInternalServerError)
 		return
@@ -51,7 +92,20 @@ func (api *CompanyAPI) HandleDeleteCompanyByName(w http.ResponseWriter, r *http.
 		return
 	}
 
-	err := api.s.DeleteCompanyByName(companyName)
+	if _, err := auth.ScopeCompanyName(r.Context(), companyName); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	err := api.s.DeleteCompanyByName(companyName, api.audit.Recorder(r.Context(), audit.ActionDelete, audit.EntityCompany))
+	if errors.Is(err, ErrCompanyNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, ErrCompanyInUse) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -67,7 +121,48 @@ func (api *CompanyAPI) HandlePostCompany(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	err := api.s.PutCompany(company)
+	if auth.CompanyScope(r.Context()) != "" {
+		http.Error(w, auth.ErrOutOfCompanyScope.Error(), http.StatusForbidden)
+		return
+	}
+
+	err := api.s.PutCompany(company, api.audit.Recorder(r.Context(), audit.ActionCreate, audit.EntityCompany))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (api *CompanyAPI) HandleArchiveCompanyByName(w http.ResponseWriter, r *http.Request) {
+	api.setCompanyArchived(w, r, true)
+}
+
+func (api *CompanyAPI) HandleRestoreCompanyByName(w http.ResponseWriter, r *http.Request) {
+	api.setCompanyArchived(w, r, false)
+}
+
+func (api *CompanyAPI) setCompanyArchived(w http.ResponseWriter, r *http.Request, archived bool) {
+	companyName := mux.Vars(r)["companyName"]
+	if companyName == "" {
+		http.Error(w, "company name not set", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := auth.ScopeCompanyName(r.Context(), companyName); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	var err error
+	if archived {
+		err = api.s.ArchiveCompanyByName(companyName, api.audit.Recorder(r.Context(), audit.ActionArchive, audit.EntityCompany))
+	} else {
+		err = api.s.RestoreCompanyByName(companyName, api.audit.Recorder(r.Context(), audit.ActionRestore, audit.EntityCompany))
+	}
+	if errors.Is(err, ErrCompanyNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -75,7 +170,16 @@ func (api *CompanyAPI) HandlePostCompany(w http.ResponseWriter, r *http.Request)
 }
 
 func (api *CompanyAPI) HandleGetCompanies(w http.ResponseWriter, r *http.Request) {
-	result, err := api.s.GetCompanies()
+	includeArchived := false
+	if value := r.URL.Query().Get("includeArchived"); value != "" {
+		var err error
+		if includeArchived, err = strconv.ParseBool(value); err != nil {
+			http.Error(w, "invalid includeArchived: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	result, err := api.s.GetCompanies(auth.CompanyScope(r.Context()), includeArchived)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -124,6 +228,13 @@ func (api *CompanyAPI) DecodeCompanyBodyHandler(next http.Handler) http.Handler
 			return
 		}
 
+		if company.TimeZone != "" {
+			if _, err := time.LoadLocation(company.TimeZone); err != nil {
+				http.Error(w, "invalid time zone: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
 		ctx := context.WithValue(r.Context(), "company", company)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
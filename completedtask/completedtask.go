@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"time"
+	"tzcnlr/audit"
 )
 
 type CompletedTask struct {
@@ -18,6 +19,17 @@ type CompletedTask struct {
 	TaskDurationInMinutes int       `json:"taskDurationInMinutes"`
 	IsRental              bool      `json:"isRental"`
 	TaskDetail            string    `json:"taskDetail"`
+	// TaskStart and TaskEnd are the instants the task ran between. They are kept in sync with
+	// the split date and time fields above, which remain for older clients.
+	TaskStart time.Time `json:"taskStart"`
+	TaskEnd   time.Time `json:"taskEnd"`
+	// TimeZone is the zone the split date and time fields are wall clock values in, set from
+	// the branch of the task when it is stored.
+	TimeZone string `json:"timeZone"`
+	// Cost is the price of the task in minor units of Currency by the rate card of its machine,
+	// nil when no card is valid on the start date. It is computed on reads and never stored.
+	Cost     *int64  `json:"cost"`
+	Currency *string `json:"currency"`
 }
 
 func (ct *CompletedTask) String() string {
@@ -25,43 +37,167 @@ func (ct *CompletedTask) String() string {
 		ct.CompanyName, ct.BranchName, ct.MachineName, ct.TaskStartDate, ct.TaskStartTime, ct.TaskEndDate, ct.TaskEndTime, ct.TaskDurationInMinutes, ct.TaskDetail, ct.IsRental)
 }
 
-func (ct *CompletedTask) FillDerivedCompletedTaskData() {
+// FillDerivedCompletedTaskData derives the end and the instants of a task recorded in loc. The
+// duration is elapsed time, a task running over a DST change ends an hour off on the wall clock.
+func (ct *CompletedTask) FillDerivedCompletedTaskData(loc *time.Location) {
+	start := wallClock(ct.TaskStartDate, ct.TaskStartTime, loc)
+	end := start.Add(time.Minute * time.Duration(ct.TaskDurationInMinutes))
 	if ct.TaskEndDate.IsZero() {
-		ct.TaskEndDate = time.Date(ct.TaskStartDate.Year(), ct.TaskStartDate.Month(), ct.TaskStartDate.Day(), ct.TaskStartTime.Hour(), ct.TaskStartTime.Minute(), ct.TaskStartTime.Second(), ct.TaskStartTime.Nanosecond(), ct.TaskStartTime.Location()).Add(time.Minute * time.Duration(ct.TaskDurationInMinutes))
+		ct.TaskEndDate = end
 	}
 	if ct.TaskEndTime.IsZero() {
-		ct.TaskEndTime = ct.TaskStartTime.Add(time.Minute * time.Duration(ct.TaskDurationInMinutes))
+		ct.TaskEndTime = end
 	}
+	ct.TaskStart = start
+	ct.TaskEnd = wallClock(ct.TaskEndDate, ct.TaskEndTime, loc)
+	ct.TimeZone = loc.String()
 	if ct.TaskDetail == "" {
 		ct.TaskDetail = "-"
 	}
 }
 
+// CompletedTaskFilter narrows the task list, zero values do not filter.
+type CompletedTaskFilter struct {
+	CompanyName  string
+	BranchName   string
+	MachineNames []string
+	IsRental     *bool
+	// StartDate and EndDate bound the start date of the task
+	StartDate time.Time
+	EndDate   time.Time
+	// EndDateFrom and EndDateTo bound the end date of the task
+	EndDateFrom time.Time
+	EndDateTo   time.Time
+	MinDuration int
+	MaxDuration int
+	// StartTimeFrom and StartTimeTo select tasks starting within a time of day window,
+	// a window with StartTimeFrom after StartTimeTo wraps around midnight
+	StartTimeFrom *time.Time
+	StartTimeTo   *time.Time
+	// Search matches a part of the task detail, case insensitive
+	Search string
+}
+
+// Cursor points at the last task of a page, the next page starts right after it.
+type Cursor struct {
+	TaskStartDate time.Time
+	TaskStartTime time.Time
+	TaskID        int
+}
+
+// Page selects a slice of the tasks ordered by start date, start time and id.
+// A zero Limit means no limit.
+type Page struct {
+	Limit      int
+	Cursor     *Cursor
+	Descending bool
+}
+
 type CompletedTaskService struct {
-	ctDB *CompletedTaskDB
+	ctDB     *CompletedTaskDB
+	location *time.Location
 }
 
-func NewCompletedTaskService(ctDB *CompletedTaskDB) *CompletedTaskService {
+// NewCompletedTaskService creates the service, location is the zone of the deployment used for
+// branches and companies without a zone of their own.
+func NewCompletedTaskService(ctDB *CompletedTaskDB, location *time.Location) *CompletedTaskService {
 	return &CompletedTaskService{
-		ctDB: ctDB,
+		ctDB:     ctDB,
+		location: location,
+	}
+}
+
+// TaskLocation returns the zone tasks of a branch are recorded in: the zone of the branch,
+// else the zone of its company, else the zone of the deployment.
+func (s *CompletedTaskService) TaskLocation(companyName, branchName string) (*time.Location, error) {
+	timeZone, err := s.ctDB.GetTimeZone(companyName, branchName)
+	if err != nil {
+		return nil, err
+	}
+	if timeZone == "" {
+		return s.location, nil
+	}
+	return loadLocation(timeZone)
+}
+
+// taskLocation returns the zone ct was decoded in, or looks it up for tasks that were not.
+func (s *CompletedTaskService) taskLocation(ct CompletedTask) (*time.Location, error) {
+	if ct.TimeZone != "" {
+		return loadLocation(ct.TimeZone)
 	}
+	return s.TaskLocation(ct.CompanyName, ct.BranchName)
 }
 
-func (s *CompletedTaskService) PutCompletedTask(ct CompletedTask) error {
-	ct.FillDerivedCompletedTaskData()
-	return s.ctDB.PutCompletedTask(ct)
+// PutCompletedTask stores a new task, it fails with an *OverlapError when the machine is
+// already booked by another task at an overlapping time. It returns the id of the new task,
+// record writes its audit entry in the same transaction.
+func (s *CompletedTaskService) PutCompletedTask(ct CompletedTask, record audit.Recorder) (int, error) {
+	loc, err := s.taskLocation(ct)
+	if err != nil {
+		return 0, err
+	}
+	ct.FillDerivedCompletedTaskData(loc)
+	return s.ctDB.PutCompletedTask(ct, record)
+}
+
+// ImportCompletedTasks inserts already validated tasks, see CompletedTaskDB.ImportCompletedTasks.
+func (s *CompletedTaskService) ImportCompletedTasks(cts []CompletedTask, dryRun bool, record audit.Recorder) ([]error, error) {
+	for i := range cts {
+		loc, err := s.taskLocation(cts[i])
+		if err != nil {
+			return nil, err
+		}
+		cts[i].FillDerivedCompletedTaskData(loc)
+	}
+	return s.ctDB.ImportCompletedTasks(cts, dryRun, record)
+}
+
+// UpdateCompletedTask replaces the task with ct.TaskID, deriving missing data like for a new task.
+func (s *CompletedTaskService) UpdateCompletedTask(ct CompletedTask, record audit.Recorder) error {
+	loc, err := s.taskLocation(ct)
+	if err != nil {
+		return err
+	}
+	ct.FillDerivedCompletedTaskData(loc)
+	return s.ctDB.UpdateCompletedTask(ct, record)
+}
+
+func (s *CompletedTaskService) GetCompletedTaskByID(taskID int) (CompletedTask, error) {
+	return s.ctDB.GetCompletedTaskByID(taskID)
+}
+
+func (s *CompletedTaskService) DeleteCompletedTaskByID(taskID int, record audit.Recorder) error {
+	return s.ctDB.DeleteCompletedTaskByID(taskID, record)
 }
 
 func (s *CompletedTaskService) ValidateCompletedTaskData(ct CompletedTask) error {
 	if !ct.TaskEndDate.IsZero() && ct.TaskStartDate.After(ct.TaskEndDate) {
 		return errors.New("task start date before task end date")
 	}
-	if !ct.TaskEndTime.IsZero() && !ct.TaskEndTime.Equal(ct.TaskStartTime.Add(time.Minute*time.Duration(ct.TaskDurationInMinutes))) {
+
+	loc, err := s.taskLocation(ct)
+	if err != nil {
+		return err
+	}
+	// the end is compared on the wall clock of the task's zone, after adding the elapsed duration
+	end := wallClock(ct.TaskStartDate, ct.TaskStartTime, loc).Add(time.Minute * time.Duration(ct.TaskDurationInMinutes))
+	if !ct.TaskEndDate.IsZero() && !sameDay(ct.TaskEndDate, end) {
+		return errors.New("task end date does not match task start + duration in minutes")
+	}
+	if !ct.TaskEndTime.IsZero() && !sameClock(ct.TaskEndTime, end) {
 		return errors.New("task end time does not match task start time + duration in minutes")
 	}
 	return nil
 }
 
-func (s *CompletedTaskService) GetCompletedTasks(companyName, branchName string, startDate, endDate time.Time) ([]CompletedTask, error) {
-	return s.ctDB.GetCompletedTasks(companyName, branchName, startDate, endDate)
+func (s *CompletedTaskService) GetCompletedTasks(filter CompletedTaskFilter, page Page) ([]CompletedTask, error) {
+	return s.ctDB.GetCompletedTasks(filter, page)
+}
+
+func (s *CompletedTaskService) EachCompletedTask(filter CompletedTaskFilter, page Page, fn func(CompletedTask) error) error {
+	return s.ctDB.EachCompletedTask(filter, page, fn)
+}
+
+func (s *CompletedTaskService) CountCompletedTasks(filter CompletedTaskFilter) (int, error) {
+	return s.ctDB.CountCompletedTasks(filter)
 }
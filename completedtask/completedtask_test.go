@@ -0,0 +1,63 @@
+package completedtask
+
+import (
+	"testing"
+	"time"
+)
+
+// wall parses a "2006-01-02 15:04" wall clock value, the zone is read from the task.
+func wall(t *testing.T, value string) time.Time {
+	t.Helper()
+	parsed, err := time.Parse("2006-01-02 15:04", value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed
+}
+
+func TestValidateCompletedTaskData(t *testing.T) {
+	tests := []struct {
+		name     string
+		zone     string
+		start    string
+		duration int
+		end      string
+		endDate  string
+		wantErr  bool
+	}{
+		{name: "end matches", start: "2024-03-01 08:30", duration: 90, end: "2024-03-01 10:00"},
+		{name: "no end", start: "2024-03-01 08:30", duration: 90},
+		{name: "end over midnight", start: "2024-03-01 23:00", duration: 120, end: "2024-03-02 01:00"},
+		{name: "end date before start", start: "2024-03-02 08:30", duration: 90, endDate: "2024-03-01 00:00", wantErr: true},
+		{name: "end time off", start: "2024-03-01 08:30", duration: 90, end: "2024-03-01 10:30", wantErr: true},
+		{name: "end date off", start: "2024-03-01 23:00", duration: 120, end: "2024-03-01 01:00", wantErr: true},
+		{name: "elapsed over a fall back", zone: "America/New_York", start: "2024-11-03 00:30", duration: 120, end: "2024-11-03 01:30"},
+		{name: "wall clock over a fall back", zone: "America/New_York", start: "2024-11-03 00:30", duration: 120, end: "2024-11-03 02:30", wantErr: true},
+	}
+	s := NewCompletedTaskService(nil, time.UTC)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ct := CompletedTask{
+				TimeZone:              "Europe/Istanbul",
+				TaskStartDate:         wall(t, tt.start),
+				TaskStartTime:         wall(t, tt.start),
+				TaskDurationInMinutes: tt.duration,
+			}
+			if tt.zone != "" {
+				ct.TimeZone = tt.zone
+			}
+			if tt.end != "" {
+				ct.TaskEndDate = wall(t, tt.end)
+				ct.TaskEndTime = wall(t, tt.end)
+			}
+			if tt.endDate != "" {
+				ct.TaskEndDate = wall(t, tt.endDate)
+			}
+
+			err := s.ValidateCompletedTaskData(ct)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("error is %v, want an error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -2,11 +2,39 @@ package completedtask
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
-	"time"
+	"strconv"
+	"strings"
+	"tzcnlr/audit"
 )
 
+var ErrCompletedTaskNotFound = errors.New("completed task does not exist")
+
+// ErrUnknownReference is returned when the company, branch or machine of a task does not exist,
+// or is archived and the task did not reference it before.
+var ErrUnknownReference = errors.New("company, branch or machine does not exist or is archived")
+
+// ErrCompletedTaskInvoiced is returned when a task on an issued invoice is changed or deleted.
+var ErrCompletedTaskInvoiced = errors.New("completed task is invoiced and can no longer be changed")
+
+// OverlapError is returned when a task books a machine that is already booked by other
+// tasks at an overlapping time, TaskIDs holds the clashing tasks.
+type OverlapError struct {
+	TaskIDs []int
+}
+
+func (e *OverlapError) Error() string {
+	ids := make([]string, len(e.TaskIDs))
+	for i, id := range e.TaskIDs {
+		ids[i] = strconv.Itoa(id)
+	}
+	return "machine is already booked at that time by completed tasks " + strings.Join(ids, ", ")
+}
+
 func NewCompletedTaskDB(db *pgxpool.Pool) *CompletedTaskDB {
 	return &CompletedTaskDB{
 		db: db,
@@ -17,17 +45,126 @@ type CompletedTaskDB struct {
 	db *pgxpool.Pool
 }
 
-func (c *CompletedTaskDB) PutCompletedTask(ct CompletedTask) error {
+// querier is satisfied by both the pool and a transaction.
+type querier interface {
+	Exec(ctx context.Context, sql string, arguments ...interface{}) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
+// PutCompletedTask inserts ct, records it with record and returns the id it got.
+func (c *CompletedTaskDB) PutCompletedTask(ct CompletedTask, record audit.Recorder) (int, error) {
+	ctx := context.Background()
+	tx, err := c.db.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	taskID, err := putCompletedTask(ctx, tx, ct)
+	if err != nil {
+		return 0, err
+	}
+	if err = recordNewCompletedTask(ctx, tx, taskID, record); err != nil {
+		return 0, err
+	}
+	return taskID, tx.Commit(ctx)
+}
+
+// recordNewCompletedTask records the state a task was stored with in the transaction that stored it.
+func recordNewCompletedTask(ctx context.Context, tx pgx.Tx, taskID int, record audit.Recorder) error {
+	after, err := lockCompletedTask(ctx, tx, taskID)
+	if err != nil {
+		return err
+	}
+	return record(tx, strconv.Itoa(taskID), nil, after)
+}
+
+// checkOverlap returns an OverlapError when another task books the machine of ct at an
+// overlapping time. It must run in a transaction, the advisory lock taken on the machine
+// serializes concurrent writers until the transaction ends.
+func checkOverlap(ctx context.Context, db querier, ct CompletedTask) error {
+	if _, err := db.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext('completed_task_logs:' || $1::text))`, ct.MachineName); err != nil {
+		return err
+	}
+
+	sql := `
+	SELECT task_id FROM completed_task_logs
+	WHERE machine_id = (SELECT machine_id FROM machine WHERE machine_name = $1)
+		AND task_id != $2
+		AND tstzrange(task_start, task_end) && tstzrange($3, $4)
+	ORDER BY task_id`
+
+	rows, err := db.Query(ctx, sql, ct.MachineName, ct.TaskID, ct.TaskStart, ct.TaskEnd)
+	if err != nil {
+		return err
+	}
+	taskIDs, err := pgx.CollectRows(rows, pgx.RowTo[int])
+	if err != nil {
+		return err
+	}
+	if len(taskIDs) > 0 {
+		return &OverlapError{TaskIDs: taskIDs}
+	}
+	return nil
+}
+
+// ImportCompletedTasks inserts the tasks in a single transaction, each in its own savepoint
+// so a failing task does not abort the others, and records every inserted task with record.
+// The returned slice holds the error of every task, nil for inserted ones. With dryRun the
+// transaction is rolled back at the end.
+func (c *CompletedTaskDB) ImportCompletedTasks(cts []CompletedTask, dryRun bool, record audit.Recorder) ([]error, error) {
+	ctx := context.Background()
+	tx, err := c.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	rowErrors := make([]error, len(cts))
+	for i, ct := range cts {
+		savepoint, err := tx.Begin(ctx)
+		if err != nil {
+			return nil, err
+		}
+		var taskID int
+		if taskID, rowErrors[i] = putCompletedTask(ctx, savepoint, ct); rowErrors[i] != nil {
+			if err = savepoint.Rollback(ctx); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err = recordNewCompletedTask(ctx, savepoint, taskID, record); err != nil {
+			return nil, err
+		}
+		if err = savepoint.Commit(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	if dryRun {
+		return rowErrors, nil
+	}
+	return rowErrors, tx.Commit(ctx)
+}
+
+func putCompletedTask(ctx context.Context, db querier, ct CompletedTask) (int, error) {
+	if err := checkOverlap(ctx, db, ct); err != nil {
+		return 0, err
+	}
+
 	query := `
 	INSERT INTO completed_task_logs (
-    	company_name, branch_name, machine_name, task_start_date, task_start_time, task_end_date, task_end_time, task_duration_in_minutes, is_rental, task_detail
+    	company_id, branch_id, machine_id, task_start_date, task_start_time, task_end_date, task_end_time, task_duration_in_minutes, is_rental, task_detail,
+		task_start, task_end, time_zone
 	) 
 	VALUES (
-		(SELECT company_name FROM company WHERE company_name = $1),
-		(SELECT branch_name FROM branch WHERE branch_name = $2 AND company_id = (SELECT company_id FROM company WHERE company_name = $1)),
-		(SELECT machine_name FROM machine WHERE machine_name = $3),
-		$4, $5, $6, $7, $8, $9, $10 
-	)`
+		(SELECT company_id FROM company WHERE company_name = $1 AND archived_at IS NULL),
+		(SELECT branch_id FROM branch WHERE branch_name = $2 AND company_id = (SELECT company_id FROM company WHERE company_name = $1) AND archived_at IS NULL),
+		(SELECT machine_id FROM machine WHERE machine_name = $3 AND archived_at IS NULL),
+		$4, $5, $6, $7, $8, $9, $10, $11, $12, $13
+	)
+	RETURNING task_id`
 
 	/* Following query is probably more performant but fails big time on type deduce mismatch
 		`
@@ -49,8 +186,9 @@ func (c *CompletedTaskDB) PutCompletedTask(ct CompletedTask) error {
 		`
 	*/
 
-	_, err := c.db.Exec(
-		context.Background(),
+	var taskID int
+	err := db.QueryRow(
+		ctx,
 		query,
 		ct.CompanyName,
 		ct.BranchName,
@@ -62,78 +200,350 @@ func (c *CompletedTaskDB) PutCompletedTask(ct CompletedTask) error {
 		ct.TaskDurationInMinutes,
 		ct.IsRental,
 		ct.TaskDetail,
-	)
+		ct.TaskStart,
+		ct.TaskEnd,
+		ct.TimeZone,
+	).Scan(&taskID)
+	return taskID, translateReferenceError(err)
+}
+
+// translateReferenceError turns the not null violation of a name that did not resolve to an id
+// into ErrUnknownReference.
+func translateReferenceError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23502" {
+		switch pgErr.ColumnName {
+		case "company_id", "branch_id", "machine_id":
+			return ErrUnknownReference
+		}
+	}
 	return err
 }
 
-func (c *CompletedTaskDB) GetCompletedTasks(companyName, branchName string, startDate, endDate time.Time) ([]CompletedTask, error) {
+// translateInvoicedError turns the error raised by the completed_task_invoiced trigger into
+// ErrCompletedTaskInvoiced.
+func translateInvoicedError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.ConstraintName == "completed_task_invoiced" {
+		return ErrCompletedTaskInvoiced
+	}
+	return err
+}
 
-	queryData := buildFilteredQuery(companyName, branchName, startDate, endDate)
-	var completedTasks []CompletedTask
-	query, params := queryData.query, queryData.params
+// completedTaskColumns are read from completedTaskTables, the names come from the referenced rows.
+const completedTaskColumns = "t.task_id, c.company_name, b.branch_name, m.machine_name, t.task_start_date, t.task_start_time, t.task_end_date, t.task_end_time, t.task_duration_in_minutes, t.is_rental, t.task_detail, t.task_start, t.task_end, t.time_zone, " + costColumns
+
+// costColumns price a task with rate r, rentals by the day and service tasks by the minute
+// rounded half up to the minor unit. Both are NULL when no rate card applies.
+const costColumns = `CASE WHEN t.is_rental THEN r.daily_rate * (t.task_duration_in_minutes / 1440)
+	ELSE (r.hourly_rate * t.task_duration_in_minutes + 30) / 60 END, r.currency`
 
-	rows, err := c.db.Query(context.Background(), query, params...)
+// completedTaskTables join the rate card of the machine valid on the start date, the one of the
+// company if there is any, else the default card.
+const completedTaskTables = `completed_task_logs t
+	JOIN company c ON c.company_id = t.company_id
+	JOIN branch b ON b.branch_id = t.branch_id
+	JOIN machine m ON m.machine_id = t.machine_id
+	LEFT JOIN LATERAL (
+		SELECT rc.hourly_rate, rc.daily_rate, rc.currency FROM rate_card rc
+		WHERE rc.machine_id = t.machine_id AND (rc.company_id = t.company_id OR rc.company_id IS NULL)
+			AND rc.valid_from <= t.task_start_date AND (rc.valid_until IS NULL OR t.task_start_date < rc.valid_until)
+		ORDER BY rc.company_id NULLS LAST
+		LIMIT 1
+	) r ON true`
+
+func scanCompletedTask(row pgx.Row) (CompletedTask, error) {
+	var completedTask CompletedTask
+	err := row.Scan(
+		&completedTask.TaskID,
+		&completedTask.CompanyName,
+		&completedTask.BranchName,
+		&completedTask.MachineName,
+		&completedTask.TaskStartDate,
+		&completedTask.TaskStartTime,
+		&completedTask.TaskEndDate,
+		&completedTask.TaskEndTime,
+		&completedTask.TaskDurationInMinutes,
+		&completedTask.IsRental,
+		&completedTask.TaskDetail,
+		&completedTask.TaskStart,
+		&completedTask.TaskEnd,
+		&completedTask.TimeZone,
+		&completedTask.Cost,
+		&completedTask.Currency)
+	if err != nil {
+		return completedTask, err
+	}
+
+	if loc, err := loadLocation(completedTask.TimeZone); err == nil {
+		completedTask.TaskStart = completedTask.TaskStart.In(loc)
+		completedTask.TaskEnd = completedTask.TaskEnd.In(loc)
+	}
+	return completedTask, nil
+}
+
+// GetTimeZone returns the zone of the branch, else of its company, or "" if neither has one.
+func (c *CompletedTaskDB) GetTimeZone(companyName, branchName string) (string, error) {
+	query := `
+	SELECT COALESCE(b.time_zone, c.time_zone, '')
+	FROM company c
+	LEFT JOIN branch b ON b.company_id = c.company_id AND b.branch_name = $2
+	WHERE c.company_name = $1`
+
+	var timeZone string
+	err := c.db.QueryRow(context.Background(), query, companyName, branchName).Scan(&timeZone)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", nil
+	}
+	return timeZone, err
+}
+
+// lockCompletedTask reads a task and locks it until tx ends, nil if it does not exist.
+func lockCompletedTask(ctx context.Context, tx pgx.Tx, taskID int) (*CompletedTask, error) {
+	query := "SELECT " + completedTaskColumns + " FROM " + completedTaskTables + " WHERE t.task_id = $1 FOR UPDATE OF t"
+
+	completedTask, err := scanCompletedTask(tx.QueryRow(ctx, query, taskID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
 	if err != nil {
 		return nil, err
 	}
+	return &completedTask, nil
+}
 
-	for rows.Next() {
-		var completedTask CompletedTask
-		err := rows.Scan(
-			&completedTask.TaskID,
-			&completedTask.CompanyName,
-			&completedTask.BranchName,
-			&completedTask.MachineName,
-			&completedTask.TaskStartDate,
-			&completedTask.TaskStartTime,
-			&completedTask.TaskEndDate,
-			&completedTask.TaskEndTime,
-			&completedTask.TaskDurationInMinutes,
-			&completedTask.IsRental,
-			&completedTask.TaskDetail)
+func (c *CompletedTaskDB) GetCompletedTaskByID(taskID int) (CompletedTask, error) {
+	query := "SELECT " + completedTaskColumns + " FROM " + completedTaskTables + " WHERE t.task_id = $1"
 
+	completedTask, err := scanCompletedTask(c.db.QueryRow(context.Background(), query, taskID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return completedTask, ErrCompletedTaskNotFound
+	}
+	return completedTask, err
+}
+
+// UpdateCompletedTask replaces the task with ct.TaskID and records the change with record.
+func (c *CompletedTaskDB) UpdateCompletedTask(ct CompletedTask, record audit.Recorder) error {
+	ctx := context.Background()
+	tx, err := c.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	before, err := lockCompletedTask(ctx, tx, ct.TaskID)
+	if err != nil {
+		return err
+	}
+	if before == nil {
+		return ErrCompletedTaskNotFound
+	}
+
+	if err = checkOverlap(ctx, tx, ct); err != nil {
+		return err
+	}
+
+	sql := `
+	UPDATE completed_task_logs t SET
+		company_id = (SELECT company_id FROM company c WHERE company_name = $1 AND (c.archived_at IS NULL OR c.company_id = t.company_id)),
+		branch_id = (SELECT branch_id FROM branch b WHERE branch_name = $2 AND company_id = (SELECT company_id FROM company WHERE company_name = $1) AND (b.archived_at IS NULL OR b.branch_id = t.branch_id)),
+		machine_id = (SELECT machine_id FROM machine m WHERE machine_name = $3 AND (m.archived_at IS NULL OR m.machine_id = t.machine_id)),
+		task_start_date = $4,
+		task_start_time = $5,
+		task_end_date = $6,
+		task_end_time = $7,
+		task_duration_in_minutes = $8,
+		is_rental = $9,
+		task_detail = $10,
+		task_start = $12,
+		task_end = $13,
+		time_zone = $14
+	WHERE task_id = $11`
+
+	_, err = tx.Exec(
+		ctx,
+		sql,
+		ct.CompanyName,
+		ct.BranchName,
+		ct.MachineName,
+		ct.TaskStartDate,
+		ct.TaskStartTime,
+		ct.TaskEndDate,
+		ct.TaskEndTime,
+		ct.TaskDurationInMinutes,
+		ct.IsRental,
+		ct.TaskDetail,
+		ct.TaskID,
+		ct.TaskStart,
+		ct.TaskEnd,
+		ct.TimeZone,
+	)
+	if err != nil {
+		return translateInvoicedError(translateReferenceError(err))
+	}
+
+	after, err := lockCompletedTask(ctx, tx, ct.TaskID)
+	if err != nil {
+		return err
+	}
+	if err = record(tx, strconv.Itoa(ct.TaskID), before, after); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// DeleteCompletedTaskByID deletes a task and records its last state with record.
+func (c *CompletedTaskDB) DeleteCompletedTaskByID(taskID int, record audit.Recorder) error {
+	ctx := context.Background()
+	return pgx.BeginFunc(ctx, c.db, func(tx pgx.Tx) error {
+		before, err := lockCompletedTask(ctx, tx, taskID)
 		if err != nil {
-			return nil, err
+			return err
 		}
+		if before == nil {
+			return ErrCompletedTaskNotFound
+		}
+
+		sql := `DELETE FROM completed_task_logs WHERE task_id = $1`
+		if _, err = tx.Exec(ctx, sql, taskID); err != nil {
+			return translateInvoicedError(err)
+		}
+		return record(tx, strconv.Itoa(taskID), before, nil)
+	})
+}
+
+// GetCompletedTasks returns one page of the tasks matching filter ordered by start and id.
+func (c *CompletedTaskDB) GetCompletedTasks(filter CompletedTaskFilter, page Page) ([]CompletedTask, error) {
+	var completedTasks []CompletedTask
+	err := c.EachCompletedTask(filter, page, func(completedTask CompletedTask) error {
 		completedTasks = append(completedTasks, completedTask)
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
-
 	return completedTasks, nil
 }
 
+// EachCompletedTask calls fn for every task of the page while reading the rows,
+// so large results never have to be held in memory. An error from fn stops the iteration.
+func (c *CompletedTaskDB) EachCompletedTask(filter CompletedTaskFilter, page Page, fn func(CompletedTask) error) error {
+	queryData := buildFilteredQuery(filter)
+
+	direction := ">"
+	order := "ASC"
+	if page.Descending {
+		direction = "<"
+		order = "DESC"
+	}
+
+	if page.Cursor != nil {
+		queryData.query += fmt.Sprintf(" AND (t.task_start_date, t.task_start_time, t.task_id) %s (%s, %s, %s)",
+			direction,
+			queryData.addParam(page.Cursor.TaskStartDate),
+			queryData.addParam(page.Cursor.TaskStartTime),
+			queryData.addParam(page.Cursor.TaskID))
+	}
+	queryData.query += fmt.Sprintf(" ORDER BY t.task_start_date %[1]s, t.task_start_time %[1]s, t.task_id %[1]s", order)
+	if page.Limit > 0 {
+		queryData.query += " LIMIT " + queryData.addParam(page.Limit)
+	}
+
+	rows, err := c.db.Query(context.Background(), queryData.query, queryData.params...)
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+	for rows.Next() {
+		completedTask, err := scanCompletedTask(rows)
+		if err != nil {
+			return err
+		}
+		if err = fn(completedTask); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// CountCompletedTasks returns the number of tasks matching filter regardless of paging.
+func (c *CompletedTaskDB) CountCompletedTasks(filter CompletedTaskFilter) (int, error) {
+	queryData := buildFilteredQuery(filter)
+	query := "SELECT count(*) FROM (" + queryData.query + ") AS filtered"
+
+	var count int
+	err := c.db.QueryRow(context.Background(), query, queryData.params...).Scan(&count)
+	return count, err
+}
+
 type queryData struct {
 	query  string
 	params []interface{}
 }
 
-func buildFilteredQuery(companyName, branchName string, startDate, endDate time.Time) queryData {
-	query := "SELECT " +
-		"task_id, company_name, branch_name, machine_name, task_start_date, task_start_time, task_end_date, task_end_time, task_duration_in_minutes, is_rental, task_detail" +
-		" FROM completed_task_logs" +
-		" WHERE 1=1"
-	params := []interface{}{}
-	paramCount := 1
+// addParam appends a parameter and returns its placeholder.
+func (q *queryData) addParam(param interface{}) string {
+	q.params = append(q.params, param)
+	return fmt.Sprintf("$%d", len(q.params))
+}
+
+// escapeLike makes wildcard characters in s match literally in a LIKE pattern.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
 
-	if companyName != "" {
-		query += fmt.Sprintf(" AND company_name = $%d", paramCount)
-		params = append(params, companyName)
-		paramCount++
+func buildFilteredQuery(filter CompletedTaskFilter) queryData {
+	q := queryData{
+		query:  "SELECT " + completedTaskColumns + " FROM " + completedTaskTables + " WHERE 1=1",
+		params: []interface{}{},
+	}
+
+	if filter.CompanyName != "" {
+		q.query += " AND c.company_name = " + q.addParam(filter.CompanyName)
+	}
+	if filter.BranchName != "" {
+		q.query += " AND b.branch_name = " + q.addParam(filter.BranchName)
 	}
-	if branchName != "" {
-		query += fmt.Sprintf(" AND branch_name = $%d", paramCount)
-		params = append(params, branchName)
-		paramCount++
+	if !filter.StartDate.IsZero() {
+		q.query += " AND t.task_start_date >= " + q.addParam(filter.StartDate)
 	}
-	if !startDate.IsZero() {
-		query += fmt.Sprintf(" AND task_start_date >= $%d", paramCount)
-		params = append(params, startDate)
-		paramCount++
+	if !filter.EndDate.IsZero() {
+		q.query += " AND t.task_start_date <= " + q.addParam(filter.EndDate)
+	}
+	if len(filter.MachineNames) > 0 {
+		q.query += " AND m.machine_name = ANY(" + q.addParam(filter.MachineNames) + ")"
+	}
+	if filter.IsRental != nil {
+		q.query += " AND t.is_rental = " + q.addParam(*filter.IsRental)
+	}
+	if !filter.EndDateFrom.IsZero() {
+		q.query += " AND t.task_end_date >= " + q.addParam(filter.EndDateFrom)
+	}
+	if !filter.EndDateTo.IsZero() {
+		q.query += " AND t.task_end_date <= " + q.addParam(filter.EndDateTo)
+	}
+	if filter.MinDuration > 0 {
+		q.query += " AND t.task_duration_in_minutes >= " + q.addParam(filter.MinDuration)
+	}
+	if filter.MaxDuration > 0 {
+		q.query += " AND t.task_duration_in_minutes <= " + q.addParam(filter.MaxDuration)
+	}
+	switch {
+	case filter.StartTimeFrom != nil && filter.StartTimeTo != nil && filter.StartTimeFrom.After(*filter.StartTimeTo):
+		q.query += fmt.Sprintf(" AND (t.task_start_time >= %s OR t.task_start_time <= %s)", q.addParam(*filter.StartTimeFrom), q.addParam(*filter.StartTimeTo))
+	case filter.StartTimeFrom != nil || filter.StartTimeTo != nil:
+		if filter.StartTimeFrom != nil {
+			q.query += " AND t.task_start_time >= " + q.addParam(*filter.StartTimeFrom)
+		}
+		if filter.StartTimeTo != nil {
+			q.query += " AND t.task_start_time <= " + q.addParam(*filter.StartTimeTo)
+		}
 	}
-	if !endDate.IsZero() {
-		query += fmt.Sprintf(" AND task_start_date <= $%d", paramCount)
-		params = append(params, endDate)
-		paramCount++
+	if filter.Search != "" {
+		q.query += ` AND t.task_detail ILIKE '%' || ` + q.addParam(escapeLike(filter.Search)) + ` || '%'`
 	}
 
-	return queryData{query: query, params: params}
+	return q
 }
@@ -0,0 +1,73 @@
+package completedtask
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildFilteredQuery(t *testing.T) {
+	yes := true
+	clock := func(hour int) *time.Time {
+		c := time.Date(0, 1, 1, hour, 0, 0, 0, time.UTC)
+		return &c
+	}
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name       string
+		filter     CompletedTaskFilter
+		wantWhere  string
+		wantParams []interface{}
+	}{
+		{name: "no filter", wantWhere: "1=1", wantParams: []interface{}{}},
+		{
+			name:       "company, branch and start dates",
+			filter:     CompletedTaskFilter{CompanyName: "Acme", BranchName: "Merkez", StartDate: day, EndDate: day},
+			wantWhere:  "1=1 AND c.company_name = $1 AND b.branch_name = $2 AND t.task_start_date >= $3 AND t.task_start_date <= $4",
+			wantParams: []interface{}{"Acme", "Merkez", day, day},
+		},
+		{
+			name:       "machines, rental, end dates and durations",
+			filter:     CompletedTaskFilter{MachineNames: []string{"Vinç", "Forklift"}, IsRental: &yes, EndDateFrom: day, EndDateTo: day, MinDuration: 30, MaxDuration: 600},
+			wantWhere:  "1=1 AND m.machine_name = ANY($1) AND t.is_rental = $2 AND t.task_end_date >= $3 AND t.task_end_date <= $4 AND t.task_duration_in_minutes >= $5 AND t.task_duration_in_minutes <= $6",
+			wantParams: []interface{}{[]string{"Vinç", "Forklift"}, true, day, day, 30, 600},
+		},
+		{
+			name:       "start time window",
+			filter:     CompletedTaskFilter{StartTimeFrom: clock(8), StartTimeTo: clock(17)},
+			wantWhere:  "1=1 AND t.task_start_time >= $1 AND t.task_start_time <= $2",
+			wantParams: []interface{}{*clock(8), *clock(17)},
+		},
+		{
+			name:       "start time window over midnight",
+			filter:     CompletedTaskFilter{StartTimeFrom: clock(22), StartTimeTo: clock(6)},
+			wantWhere:  "1=1 AND (t.task_start_time >= $1 OR t.task_start_time <= $2)",
+			wantParams: []interface{}{*clock(22), *clock(6)},
+		},
+		{
+			name:       "open start time window",
+			filter:     CompletedTaskFilter{StartTimeTo: clock(6)},
+			wantWhere:  "1=1 AND t.task_start_time <= $1",
+			wantParams: []interface{}{*clock(6)},
+		},
+		{
+			name:       "search matches wildcards literally",
+			filter:     CompletedTaskFilter{Search: `50%_off\\`},
+			wantWhere:  "1=1 AND t.task_detail ILIKE '%' || $1 || '%'",
+			wantParams: []interface{}{`50\%\_off\\\\`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := buildFilteredQuery(tt.filter)
+			_, where, ok := strings.Cut(q.query, " WHERE ")
+			if !ok || where != tt.wantWhere {
+				t.Errorf("where is %q, want %q", where, tt.wantWhere)
+			}
+			if !reflect.DeepEqual(q.params, tt.wantParams) {
+				t.Errorf("params are %v, want %v", q.params, tt.wantParams)
+			}
+		})
+	}
+}
@@ -0,0 +1,216 @@
+package completedtask
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	mimeCSV  = "text/csv"
+	mimeXLSX = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+)
+
+// exportHeaders holds the column titles per language, in the order of exportRow.
+var exportHeaders = map[string][]string{
+	"tr": {"No", "Firma", "Şube", "Makine", "Başlangıç Tarihi", "Başlangıç Saati", "Bitiş Tarihi", "Bitiş Saati", "Süre (dk)", "Kiralama", "Detay"},
+	"en": {"ID", "Company", "Branch", "Machine", "Start Date", "Start Time", "End Date", "End Time", "Duration (min)", "Rental", "Detail"},
+}
+
+var exportYesNo = map[string][2]string{
+	"tr": {"Evet", "Hayır"},
+	"en": {"Yes", "No"},
+}
+
+// exportCell is either a number or a text value of an exported row.
+type exportCell struct {
+	text     string
+	number   int
+	isNumber bool
+}
+
+// exportRow formats a task on the wall clock of its TimeZone, the zone of its branch when it
+// was stored, as the dates and times are kept in that zone.
+func exportRow(ct CompletedTask, lang string) []exportCell {
+	rental := exportYesNo[lang][1]
+	if ct.IsRental {
+		rental = exportYesNo[lang][0]
+	}
+	return []exportCell{
+		{number: ct.TaskID, isNumber: true},
+		{text: ct.CompanyName},
+		{text: ct.BranchName},
+		{text: ct.MachineName},
+		{text: ct.TaskStartDate.Format("02.01.2006")},
+		{text: ct.TaskStartTime.Format("15:04")},
+		{text: ct.TaskEndDate.Format("02.01.2006")},
+		{text: ct.TaskEndTime.Format("15:04")},
+		{number: ct.TaskDurationInMinutes, isNumber: true},
+		{text: rental},
+		{text: ct.TaskDetail},
+	}
+}
+
+// spreadsheetText keeps text typed in by users from being run as a formula when a csv export is
+// opened in a spreadsheet, a leading quote makes the spreadsheet show it as plain text. The "-"
+// stored for tasks without detail is no formula and stays as it is. Xlsx cells are typed as
+// text and never evaluated, a quote there would show up as part of the text.
+func spreadsheetText(text string) string {
+	if text != "" && text != "-" && strings.ContainsRune("=+-@\t\r", rune(text[0])) {
+		return "'" + text
+	}
+	return text
+}
+
+// unquoteSpreadsheetText undoes spreadsheetText for text read back from an export.
+func unquoteSpreadsheetText(text string) string {
+	if len(text) > 1 && text[0] == '\'' && strings.ContainsRune("=+-@\t\r", rune(text[1])) {
+		return text[1:]
+	}
+	return text
+}
+
+type taskExporter interface {
+	WriteRow(cells []exportCell) error
+	Close() error
+}
+
+type csvExporter struct {
+	w *csv.Writer
+}
+
+func newCSVExporter(w io.Writer, headers []string) (*csvExporter, error) {
+	// the byte order mark makes Excel read the file as UTF-8
+	if _, err := io.WriteString(w, "\uFEFF"); err != nil {
+		return nil, err
+	}
+	e := &csvExporter{w: csv.NewWriter(w)}
+	return e, e.w.Write(headers)
+}
+
+func (e *csvExporter) WriteRow(cells []exportCell) error {
+	record := make([]string, len(cells))
+	for i, cell := range cells {
+		if cell.isNumber {
+			record[i] = strconv.Itoa(cell.number)
+		} else {
+			record[i] = spreadsheetText(cell.text)
+		}
+	}
+	return e.w.Write(record)
+}
+
+func (e *csvExporter) Close() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// xlsxExporter writes a single sheet workbook with inline strings, which needs
+// no shared string table and can therefore be streamed row by row.
+type xlsxExporter struct {
+	zw    *zip.Writer
+	sheet *bufio.Writer
+	row   int
+}
+
+var xlsxStaticParts = []struct{ name, content string }{
+	{"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">
+<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>
+<Default Extension="xml" ContentType="application/xml"/>
+<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>
+<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>
+</Types>`},
+	{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>
+</Relationships>`},
+	{"xl/workbook.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<sheets><sheet name="Tasks" sheetId="1" r:id="rId1"/></sheets>
+</workbook>`},
+	{"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>
+</Relationships>`},
+}
+
+func newXLSXExporter(w io.Writer, headers []string) (*xlsxExporter, error) {
+	zw := zip.NewWriter(w)
+	for _, part := range xlsxStaticParts {
+		f, err := zw.Create(part.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = io.WriteString(f, part.content); err != nil {
+			return nil, err
+		}
+	}
+
+	f, err := zw.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, err
+	}
+	e := &xlsxExporter{zw: zw, sheet: bufio.NewWriter(f)}
+	e.sheet.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n" +
+		`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+
+	headerCells := make([]exportCell, len(headers))
+	for i, header := range headers {
+		headerCells[i] = exportCell{text: header}
+	}
+	return e, e.WriteRow(headerCells)
+}
+
+func (e *xlsxExporter) WriteRow(cells []exportCell) error {
+	e.row++
+	fmt.Fprintf(e.sheet, `<row r="%d">`, e.row)
+	for i, cell := range cells {
+		ref := columnName(i) + strconv.Itoa(e.row)
+		if cell.isNumber {
+			fmt.Fprintf(e.sheet, `<c r="%s"><v>%d</v></c>`, ref, cell.number)
+			continue
+		}
+		fmt.Fprintf(e.sheet, `<c r="%s" t="inlineStr"><is><t xml:space="preserve">`, ref)
+		if err := xml.EscapeText(e.sheet, []byte(cell.text)); err != nil {
+			return err
+		}
+		e.sheet.WriteString(`</t></is></c>`)
+	}
+	_, err := e.sheet.WriteString(`</row>`)
+	return err
+}
+
+func (e *xlsxExporter) Close() error {
+	e.sheet.WriteString(`</sheetData></worksheet>`)
+	if err := e.sheet.Flush(); err != nil {
+		return err
+	}
+	return e.zw.Close()
+}
+
+// columnName turns a zero based column index into its spreadsheet letters.
+func columnName(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+	return name
+}
+
+// exportLanguage picks the header language from the lang parameter or Accept-Language,
+// defaulting to Turkish.
+func exportLanguage(lang, acceptLanguage string) string {
+	if _, ok := exportHeaders[lang]; ok {
+		return lang
+	}
+	if strings.HasPrefix(strings.ToLower(strings.TrimSpace(acceptLanguage)), "en") {
+		return "en"
+	}
+	return "tr"
+}
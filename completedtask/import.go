@@ -0,0 +1,208 @@
+package completedtask
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxImportRows bounds a single import so it fits comfortably into one transaction.
+const maxImportRows = 10000
+
+// importRow is a parsed row of an import, err is set when the row could not be parsed.
+type importRow struct {
+	task CompletedTask
+	err  error
+}
+
+// ImportRowError reports why a row of an import was rejected, rows are numbered from 1.
+type ImportRowError struct {
+	Row   int    `json:"row"`
+	Error string `json:"error"`
+}
+
+// ImportResult is the response of an import, with dryRun nothing is stored.
+type ImportResult struct {
+	DryRun   bool             `json:"dryRun"`
+	Total    int              `json:"total"`
+	Inserted int              `json:"inserted"`
+	Failed   int              `json:"failed"`
+	Errors   []ImportRowError `json:"errors"`
+}
+
+// importColumns maps the accepted column titles, the json field names and the export
+// headers of every language, onto the json field name.
+var importColumns = func() map[string]string {
+	fields := []string{"id", "companyName", "branchName", "machineName", "taskStartDate", "taskStartTime", "taskEndDate", "taskEndTime", "taskDurationInMinutes", "isRental", "taskDetail"}
+	columns := make(map[string]string)
+	for _, field := range fields {
+		columns[strings.ToLower(field)] = field
+	}
+	for _, headers := range exportHeaders {
+		for i, header := range headers {
+			columns[strings.ToLower(header)] = fields[i]
+		}
+	}
+	columns["taskstart"] = "taskStart"
+	columns["taskend"] = "taskEnd"
+	return columns
+}()
+
+// isJSONImport reports whether the body of an import is a json array rather than csv.
+func isJSONImport(contentType string, body []byte) bool {
+	if strings.HasPrefix(contentType, "application/json") {
+		return true
+	}
+	return bytes.HasPrefix(bytes.TrimSpace(body), []byte("["))
+}
+
+func parseJSONImport(body []byte, locate locator) ([]importRow, error) {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return nil, fmt.Errorf("decode error: %w", err)
+	}
+	if len(raw) > maxImportRows {
+		return nil, fmt.Errorf("too many rows, at most %d are allowed", maxImportRows)
+	}
+
+	rows := make([]importRow, len(raw))
+	for i, item := range raw {
+		rows[i].task, rows[i].err = decodeCompletedTask(item, locate)
+	}
+	return rows, nil
+}
+
+// parseCSVImport reads a csv with a header line. Both comma and semicolon separated files
+// are accepted, so files saved by a spreadsheet with a Turkish locale import as well.
+func parseCSVImport(body []byte, locate locator) ([]importRow, error) {
+	body = bytes.TrimPrefix(body, []byte("\uFEFF"))
+
+	reader := csv.NewReader(bytes.NewReader(body))
+	firstLine, _, _ := bytes.Cut(body, []byte("\n"))
+	if bytes.Count(firstLine, []byte(";")) > bytes.Count(firstLine, []byte(",")) {
+		reader.Comma = ';'
+	}
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		if err == io.EOF {
+			return nil, errors.New("empty csv")
+		}
+		return nil, fmt.Errorf("csv header: %w", err)
+	}
+	fields := make([]string, len(header))
+	for i, title := range header {
+		field, ok := importColumns[strings.ToLower(strings.TrimSpace(title))]
+		if !ok {
+			return nil, fmt.Errorf("unknown column %q", title)
+		}
+		fields[i] = field
+	}
+
+	var rows []importRow
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if len(rows) == maxImportRows {
+			return nil, fmt.Errorf("too many rows, at most %d are allowed", maxImportRows)
+		}
+		if err != nil {
+			rows = append(rows, importRow{err: err})
+			continue
+		}
+		ct, err := parseCSVRecord(fields, record, locate)
+		rows = append(rows, importRow{task: ct, err: err})
+	}
+	return rows, nil
+}
+
+// parseCSVRecord reads a row, its dates and times are wall clock values of the zone of its branch.
+// Text quoted by a csv export against formulas is taken without the quote.
+func parseCSVRecord(fields, record []string, locate locator) (CompletedTask, error) {
+	var ct CompletedTask
+	if len(record) != len(fields) {
+		return ct, fmt.Errorf("expected %d columns, got %d", len(fields), len(record))
+	}
+
+	var err error
+	for i, field := range fields {
+		value := strings.TrimSpace(record[i])
+		if value == "" {
+			continue
+		}
+		switch field {
+		case "id":
+		case "companyName":
+			ct.CompanyName = unquoteSpreadsheetText(value)
+		case "branchName":
+			ct.BranchName = unquoteSpreadsheetText(value)
+		case "machineName":
+			ct.MachineName = unquoteSpreadsheetText(value)
+		case "taskStartDate":
+			ct.TaskStartDate, err = parseImportTime(value, "2006-01-02", "02.01.2006")
+		case "taskStartTime":
+			ct.TaskStartTime, err = parseImportTime(value, "15:04", "15:04:05")
+		case "taskEndDate":
+			ct.TaskEndDate, err = parseImportTime(value, "2006-01-02", "02.01.2006")
+		case "taskEndTime":
+			ct.TaskEndTime, err = parseImportTime(value, "15:04", "15:04:05")
+		case "taskStart":
+			ct.TaskStart, err = time.Parse(time.RFC3339, value)
+		case "taskEnd":
+			ct.TaskEnd, err = time.Parse(time.RFC3339, value)
+		case "taskDurationInMinutes":
+			ct.TaskDurationInMinutes, err = strconv.Atoi(value)
+		case "isRental":
+			ct.IsRental, err = parseImportBool(value)
+		case "taskDetail":
+			ct.TaskDetail = unquoteSpreadsheetText(value)
+		}
+		if err != nil {
+			return ct, fmt.Errorf("%s: %w", field, err)
+		}
+	}
+
+	loc, err := locate(ct.CompanyName, ct.BranchName)
+	if err != nil {
+		return ct, fmt.Errorf("date&time conversion error: %w", err)
+	}
+	ct.TimeZone = loc.String()
+	for _, t := range []*time.Time{&ct.TaskStartDate, &ct.TaskStartTime, &ct.TaskEndDate, &ct.TaskEndTime} {
+		if !t.IsZero() {
+			*t = wallClock(*t, *t, loc)
+		}
+	}
+	applyTaskInstants(&ct, loc)
+	return ct, nil
+}
+
+// parseImportTime parses a wall clock value in the first matching layout.
+func parseImportTime(value string, layouts ...string) (time.Time, error) {
+	for _, layout := range layouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid value %q, expected one of %s", value, strings.Join(layouts, ", "))
+}
+
+func parseImportBool(value string) (bool, error) {
+	for _, yesNo := range exportYesNo {
+		if strings.EqualFold(value, yesNo[0]) {
+			return true, nil
+		}
+		if strings.EqualFold(value, yesNo[1]) {
+			return false, nil
+		}
+	}
+	return strconv.ParseBool(value)
+}
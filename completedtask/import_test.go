@@ -0,0 +1,182 @@
+package completedtask
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func loadZone(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loc
+}
+
+func istanbul(t *testing.T) *time.Location {
+	return loadZone(t, "Europe/Istanbul")
+}
+
+func fixedLocator(loc *time.Location) locator {
+	return func(string, string) (*time.Location, error) { return loc, nil }
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	loc := istanbul(t)
+	details := []string{"-", "-5 bar", "=x", "+90 532", "@home", "'quoted", "plain detail"}
+
+	for _, detail := range details {
+		t.Run(detail, func(t *testing.T) {
+			start := time.Date(2024, 3, 1, 8, 30, 0, 0, loc)
+			ct := CompletedTask{
+				TaskID:                7,
+				CompanyName:           "=Acme",
+				BranchName:            "-Merkez",
+				MachineName:           "@Vinç",
+				TaskStartDate:         start,
+				TaskStartTime:         start,
+				TaskEndDate:           start.Add(90 * time.Minute),
+				TaskEndTime:           start.Add(90 * time.Minute),
+				TaskDurationInMinutes: 90,
+				IsRental:              true,
+				TaskDetail:            detail,
+			}
+
+			var buf bytes.Buffer
+			e, err := newCSVExporter(&buf, exportHeaders["en"])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = e.WriteRow(exportRow(ct, "en")); err != nil {
+				t.Fatal(err)
+			}
+			if err = e.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			rows, err := parseCSVImport(buf.Bytes(), fixedLocator(loc))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(rows) != 1 || rows[0].err != nil {
+				t.Fatalf("import of %q gave %+v", buf.String(), rows)
+			}
+
+			got := rows[0].task
+			if got.CompanyName != ct.CompanyName || got.BranchName != ct.BranchName || got.MachineName != ct.MachineName || got.TaskDetail != ct.TaskDetail {
+				t.Errorf("imported %q, %q, %q, %q, want %q, %q, %q, %q", got.CompanyName, got.BranchName, got.MachineName, got.TaskDetail,
+					ct.CompanyName, ct.BranchName, ct.MachineName, ct.TaskDetail)
+			}
+			if !wallClock(got.TaskStartDate, got.TaskStartTime, loc).Equal(start) || got.TaskDurationInMinutes != 90 || !got.IsRental {
+				t.Errorf("imported a start of %v, %d minutes, rental %v", wallClock(got.TaskStartDate, got.TaskStartTime, loc), got.TaskDurationInMinutes, got.IsRental)
+			}
+		})
+	}
+}
+
+func TestParseCSVImport(t *testing.T) {
+	loc := istanbul(t)
+	locate := func(companyName, branchName string) (*time.Location, error) {
+		if branchName == "Depo" {
+			return nil, errors.New("branchName does not exist")
+		}
+		return loc, nil
+	}
+
+	tests := []struct {
+		name    string
+		body    string
+		wantErr bool
+		// wantRows holds the start, duration, rental and detail of every row, or the field of
+		// its error
+		wantRows []string
+	}{
+		{
+			name:     "json field names",
+			body:     "companyName,branchName,machineName,taskStartDate,taskStartTime,taskDurationInMinutes,isRental,taskDetail\nAcme,Merkez,Vinç,2024-03-01,08:30,90,true,lift\n",
+			wantRows: []string{"2024-03-01 08:30 90 true lift"},
+		},
+		{
+			name:     "turkish export with a bom and semicolons",
+			body:     "\uFEFFNo;Firma;Şube;Makine;Başlangıç Tarihi;Başlangıç Saati;Bitiş Tarihi;Bitiş Saati;Süre (dk);Kiralama;Detay\n7;Acme;Merkez;Vinç;01.03.2024;23:30;02.03.2024;01:00;90;Evet;'=1+1\n",
+			wantRows: []string{"2024-03-01 23:30 90 true =1+1"},
+		},
+		{
+			name:     "instants",
+			body:     "companyName,branchName,machineName,taskStart,taskEnd,isRental\nAcme,Merkez,Vinç,2024-03-01T05:30:00Z,2024-03-01T07:00:00Z,Hayır\n",
+			wantRows: []string{"2024-03-01 08:30 90 false "},
+		},
+		{
+			name: "rows failing on their own",
+			body: "companyName,branchName,machineName,taskStartDate,taskStartTime,taskDurationInMinutes\n" +
+				"Acme,Merkez,Vinç,2024-03-01,08:30,90\n" +
+				"Acme,Merkez,Vinç,2024-03-01\n" +
+				"Acme,Merkez,Vinç,2024-03-32,08:30,90\n" +
+				"Acme,Merkez,Vinç,2024-03-01,08:30,ninety\n" +
+				"Acme,Depo,Vinç,2024-03-01,08:30,90\n",
+			wantRows: []string{"2024-03-01 08:30 90 false ", "columns", "taskStartDate", "taskDurationInMinutes", "date&time"},
+		},
+		{name: "unknown column", body: "companyName,taskName\nAcme,lift\n", wantErr: true},
+		{name: "empty", body: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows, err := parseCSVImport([]byte(tt.body), locate)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error is %v, want an error %v", err, tt.wantErr)
+			}
+			if len(rows) != len(tt.wantRows) {
+				t.Fatalf("got %d rows, want %d", len(rows), len(tt.wantRows))
+			}
+			for i, row := range rows {
+				if row.err != nil {
+					if !strings.Contains(row.err.Error(), tt.wantRows[i]) {
+						t.Errorf("row %d failed with %v, want %s", i+1, row.err, tt.wantRows[i])
+					}
+					continue
+				}
+				ct := row.task
+				got := fmt.Sprintf("%s %d %v %s", wallClock(ct.TaskStartDate, ct.TaskStartTime, time.UTC).Format("2006-01-02 15:04"), ct.TaskDurationInMinutes, ct.IsRental, ct.TaskDetail)
+				if got != tt.wantRows[i] {
+					t.Errorf("row %d is %q, want %q", i+1, got, tt.wantRows[i])
+				}
+				if ct.TimeZone != "Europe/Istanbul" {
+					t.Errorf("row %d is in zone %q, want Europe/Istanbul", i+1, ct.TimeZone)
+				}
+			}
+		})
+	}
+}
+
+func TestSpreadsheetText(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"", ""},
+		{"-", "-"},
+		{"lift", "lift"},
+		{"=SUM(A1:A9)", "'=SUM(A1:A9)"},
+		{"+90 532", "'+90 532"},
+		{"-5 bar", "'-5 bar"},
+		{"@home", "'@home"},
+		{"\tx", "'\tx"},
+		{"\rx", "'\rx"},
+		{"'quoted", "'quoted"},
+		{"a=b", "a=b"},
+	}
+	for _, tt := range tests {
+		got := spreadsheetText(tt.text)
+		if got != tt.want {
+			t.Errorf("spreadsheetText(%q) is %q, want %q", tt.text, got, tt.want)
+		}
+		if back := unquoteSpreadsheetText(got); back != tt.text {
+			t.Errorf("unquoteSpreadsheetText(%q) is %q, want %q", got, back, tt.text)
+		}
+	}
+}
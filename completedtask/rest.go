@@ -1,21 +1,31 @@
 package completedtask
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/gorilla/mux"
+	"log"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 	_ "time/tzdata"
+	"tzcnlr/audit"
+	"tzcnlr/auth"
 )
 
 type CompletedTaskAPI struct {
-	s *CompletedTaskService
+	s     *CompletedTaskService
+	audit *audit.AuditService
 }
 
-func NewCompletedTaskAPI(s *CompletedTaskService) *CompletedTaskAPI {
+func NewCompletedTaskAPI(s *CompletedTaskService, auditService *audit.AuditService) *CompletedTaskAPI {
 	return &CompletedTaskAPI{
-		s: s,
+		s:     s,
+		audit: auditService,
 	}
 }
 
@@ -32,7 +42,7 @@ func (api *CompletedTaskAPI) HandlePostCompletedTask(w http.ResponseWriter, r *h
 		return
 	}
 
-	ct, err := decodeCompletedTask(body)
+	ct, err := decodeCompletedTask(body, api.s.TaskLocation)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -43,35 +53,301 @@ func (api *CompletedTaskAPI) HandlePostCompletedTask(w http.ResponseWriter, r *h
 		return
 	}
 
+	if _, err = auth.ScopeCompanyName(r.Context(), ct.CompanyName); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
 	if err = api.s.ValidateCompletedTaskData(ct); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	if err = api.s.PutCompletedTask(ct); err != nil {
+	_, err = api.s.PutCompletedTask(ct, api.audit.Recorder(r.Context(), audit.ActionCreate, audit.EntityCompletedTask))
+	if errors.Is(err, ErrUnknownReference) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if overlapErr := (*OverlapError)(nil); errors.As(err, &overlapErr) {
+		writeOverlapError(w, overlapErr)
+		return
+	}
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+}
+
+// HandleImportCompletedTasks imports a csv file or a json array of tasks. Every row is
+// checked like a single post, the valid rows are inserted in one transaction and the
+// rejected ones are reported by row number. With dryRun=true nothing is stored.
+func (api *CompletedTaskAPI) HandleImportCompletedTasks(w http.ResponseWriter, r *http.Request) {
+	body, ok := r.Context().Value("body").([]byte)
+	if !ok {
+		http.Error(w, "error accessing the body of the request", http.StatusInternalServerError)
+		return
+	}
+	if len(body) == 0 {
+		http.Error(w, "empty request body", http.StatusBadRequest)
+		return
+	}
+
+	dryRun := false
+	if value := r.URL.Query().Get("dryRun"); value != "" {
+		var err error
+		if dryRun, err = strconv.ParseBool(value); err != nil {
+			http.Error(w, "invalid dryRun: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	var rows []importRow
+	var err error
+	if isJSONImport(r.Header.Get("Content-Type"), body) {
+		rows, err = parseJSONImport(body, cachedLocator(api.s.TaskLocation))
+	} else {
+		rows, err = parseCSVImport(body, cachedLocator(api.s.TaskLocation))
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result := ImportResult{DryRun: dryRun, Total: len(rows), Errors: []ImportRowError{}}
+	var valid []CompletedTask
+	var validRows []int
+	for i, row := range rows {
+		if row.err == nil {
+			row.err = checkMissingBodyInput(row.task)
+		}
+		if row.err == nil {
+			_, row.err = auth.ScopeCompanyName(r.Context(), row.task.CompanyName)
+		}
+		if row.err == nil {
+			row.err = api.s.ValidateCompletedTaskData(row.task)
+		}
+		if row.err != nil {
+			result.Errors = append(result.Errors, ImportRowError{Row: i + 1, Error: row.err.Error()})
+			continue
+		}
+		valid = append(valid, row.task)
+		validRows = append(validRows, i+1)
+	}
+
+	if len(valid) > 0 {
+		// every inserted task is recorded on its own, with its id and the state it was stored with
+		rowErrors, err := api.s.ImportCompletedTasks(valid, dryRun, api.audit.Recorder(r.Context(), audit.ActionImport, audit.EntityCompletedTask))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for i, rowErr := range rowErrors {
+			if rowErr != nil {
+				result.Errors = append(result.Errors, ImportRowError{Row: validRows[i], Error: rowErr.Error()})
+				continue
+			}
+			result.Inserted++
+		}
+		sort.Slice(result.Errors, func(i, j int) bool { return result.Errors[i].Row < result.Errors[j].Row })
+	}
+	result.Failed = len(result.Errors)
 
+	jsonResponse, err := json.Marshal(result)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
 }
 
-func (api *CompletedTaskAPI) HandleGetCompletedTask(w http.ResponseWriter, r *http.Request) {
-	companyName := r.URL.Query().Get("companyName")
-	branchName := r.URL.Query().Get("branchName")
+func (api *CompletedTaskAPI) HandleGetCompletedTaskByID(w http.ResponseWriter, r *http.Request) {
+	ct, ok := api.getScopedCompletedTask(w, r)
+	if !ok {
+		return
+	}
+
+	jsonResponse, err := json.Marshal(ct)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
+
+func (api *CompletedTaskAPI) HandleUpdateCompletedTask(w http.ResponseWriter, r *http.Request) {
+	current, ok := api.getScopedCompletedTask(w, r)
+	if !ok {
+		return
+	}
+
+	body, ok := r.Context().Value("body").([]byte)
+	if !ok {
+		http.Error(w, "error accessing the body of the request", http.StatusInternalServerError)
+		return
+	}
+
+	if len(body) == 0 {
+		http.Error(w, "empty request body", http.StatusBadRequest)
+		return
+	}
+
+	ct, err := decodeCompletedTask(body, api.s.TaskLocation)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ct.TaskID = current.TaskID
+
+	api.updateCompletedTask(w, r, ct)
+}
+
+func (api *CompletedTaskAPI) HandlePatchCompletedTask(w http.ResponseWriter, r *http.Request) {
+	current, ok := api.getScopedCompletedTask(w, r)
+	if !ok {
+		return
+	}
+
+	body, ok := r.Context().Value("body").([]byte)
+	if !ok {
+		http.Error(w, "error accessing the body of the request", http.StatusInternalServerError)
+		return
+	}
+
+	if len(body) == 0 {
+		http.Error(w, "empty request body", http.StatusBadRequest)
+		return
+	}
+
+	ct, err := patchCompletedTask(body, current, api.s.TaskLocation)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	api.updateCompletedTask(w, r, ct)
+}
+
+func (api *CompletedTaskAPI) HandleDeleteCompletedTask(w http.ResponseWriter, r *http.Request) {
+	current, ok := api.getScopedCompletedTask(w, r)
+	if !ok {
+		return
+	}
 
-	startDate, err := parseDate(r.URL.Query().Get("startDate"))
+	err := api.s.DeleteCompletedTaskByID(current.TaskID, api.audit.Recorder(r.Context(), audit.ActionDelete, audit.EntityCompletedTask))
+	if errors.Is(err, ErrCompletedTaskNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, ErrCompletedTaskInvoiced) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+}
 
-	endDate, err := parseDate(r.URL.Query().Get("endDate"))
+// getScopedCompletedTask loads the task named by the id URL variable and writes
+// the error response itself if it can not be loaded or is outside the caller's company.
+func (api *CompletedTaskAPI) getScopedCompletedTask(w http.ResponseWriter, r *http.Request) (CompletedTask, bool) {
+	taskID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "invalid task id", http.StatusBadRequest)
+		return CompletedTask{}, false
+	}
+
+	ct, err := api.s.GetCompletedTaskByID(taskID)
+	if errors.Is(err, ErrCompletedTaskNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return CompletedTask{}, false
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return CompletedTask{}, false
+	}
+
+	if _, err = auth.ScopeCompanyName(r.Context(), ct.CompanyName); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return CompletedTask{}, false
+	}
+	return ct, true
+}
+
+// updateCompletedTask replaces the task with the id of ct by ct.
+func (api *CompletedTaskAPI) updateCompletedTask(w http.ResponseWriter, r *http.Request, ct CompletedTask) {
+	if err := checkMissingBodyInput(ct); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if _, err := auth.ScopeCompanyName(r.Context(), ct.CompanyName); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	if err := api.s.ValidateCompletedTaskData(ct); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err := api.s.UpdateCompletedTask(ct, api.audit.Recorder(r.Context(), audit.ActionUpdate, audit.EntityCompletedTask))
+	if errors.Is(err, ErrCompletedTaskNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, ErrCompletedTaskInvoiced) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if errors.Is(err, ErrUnknownReference) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if overlapErr := (*OverlapError)(nil); errors.As(err, &overlapErr) {
+		writeOverlapError(w, overlapErr)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (api *CompletedTaskAPI) HandleGetCompletedTask(w http.ResponseWriter, r *http.Request) {
+	if format := exportFormatFromAccept(r.Header.Get("Accept")); format != "" {
+		api.exportCompletedTasks(w, r, format)
+		return
+	}
+
+	filter, err := parseCompletedTaskFilter(r)
+	if errors.Is(err, auth.ErrOutOfCompanyScope) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	result, err := api.s.GetCompletedTasks(companyName, branchName, startDate, endDate)
+	page, err := parsePage(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := api.s.GetCompletedTasks(filter, page)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
+	total, err := api.s.CountCompletedTasks(filter)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -88,11 +364,279 @@ func (api *CompletedTaskAPI) HandleGetCompletedTask(w http.ResponseWriter, r *ht
 		return
 	}
 
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	if page.Limit > 0 && len(result) == page.Limit {
+		last := result[len(result)-1]
+		w.Header().Set("X-Next-Cursor", encodeCursor(Cursor{
+			TaskStartDate: last.TaskStartDate,
+			TaskStartTime: last.TaskStartTime,
+			TaskID:        last.TaskID,
+		}))
+	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	w.Write(jsonResponse)
 }
 
+// writeOverlapError answers 409 with the ids of the tasks clashing with the rejected one.
+func writeOverlapError(w http.ResponseWriter, err *OverlapError) {
+	jsonResponse, jsonErr := json.Marshal(struct {
+		Error              string `json:"error"`
+		ConflictingTaskIDs []int  `json:"conflictingTaskIds"`
+	}{err.Error(), err.TaskIDs})
+	if jsonErr != nil {
+		http.Error(w, jsonErr.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusConflict)
+	w.Write(jsonResponse)
+}
+
+// HandleExportCompletedTasks streams the filtered task list as csv or xlsx, chosen by
+// the format parameter or the Accept header.
+func (api *CompletedTaskAPI) HandleExportCompletedTasks(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = exportFormatFromAccept(r.Header.Get("Accept"))
+	}
+	if format != "csv" && format != "xlsx" {
+		http.Error(w, "format must be csv or xlsx", http.StatusBadRequest)
+		return
+	}
+
+	api.exportCompletedTasks(w, r, format)
+}
+
+func (api *CompletedTaskAPI) exportCompletedTasks(w http.ResponseWriter, r *http.Request, format string) {
+	filter, err := parseCompletedTaskFilter(r)
+	if errors.Is(err, auth.ErrOutOfCompanyScope) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// the export holds the whole result, only the order is taken from the paging parameters
+	page, err := parsePage(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	page = Page{Descending: page.Descending}
+
+	lang := exportLanguage(r.URL.Query().Get("lang"), r.Header.Get("Accept-Language"))
+	fileName := "completed-tasks-" + time.Now().Format("20060102") + "." + format
+
+	contentType := mimeCSV + "; charset=utf-8"
+	if format == "xlsx" {
+		contentType = mimeXLSX
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="`+fileName+`"`)
+
+	var exporter taskExporter
+	if format == "xlsx" {
+		exporter, err = newXLSXExporter(w, exportHeaders[lang])
+	} else {
+		exporter, err = newCSVExporter(w, exportHeaders[lang])
+	}
+	if err != nil {
+		log.Printf("error starting completed task export: %v\n", err)
+		return
+	}
+
+	// the status is already sent once rows are streamed, so failures can only be logged
+	err = api.s.EachCompletedTask(filter, page, func(ct CompletedTask) error {
+		return exporter.WriteRow(exportRow(ct, lang))
+	})
+	if err != nil {
+		log.Printf("error during completed task export: %v\n", err)
+		return
+	}
+	if err = exporter.Close(); err != nil {
+		log.Printf("error finishing completed task export: %v\n", err)
+	}
+}
+
+func exportFormatFromAccept(accept string) string {
+	for _, mediaRange := range strings.Split(accept, ",") {
+		mediaType, _, _ := strings.Cut(mediaRange, ";")
+		switch strings.TrimSpace(mediaType) {
+		case mimeCSV:
+			return "csv"
+		case mimeXLSX:
+			return "xlsx"
+		}
+	}
+	return ""
+}
+
+func parseCompletedTaskFilter(r *http.Request) (CompletedTaskFilter, error) {
+	var filter CompletedTaskFilter
+	query := r.URL.Query()
+
+	companyName, err := auth.ScopeCompanyName(r.Context(), query.Get("companyName"))
+	if err != nil {
+		return filter, err
+	}
+	filter.CompanyName = companyName
+	filter.BranchName = query.Get("branchName")
+
+	filter.StartDate, err = parseDate(query.Get("startDate"))
+	if err != nil {
+		return filter, err
+	}
+
+	filter.EndDate, err = parseDate(query.Get("endDate"))
+	if err != nil {
+		return filter, err
+	}
+
+	// machineName may be repeated or hold a comma separated list
+	for _, machineNames := range query["machineName"] {
+		for _, machineName := range strings.Split(machineNames, ",") {
+			if machineName = strings.TrimSpace(machineName); machineName != "" {
+				filter.MachineNames = append(filter.MachineNames, machineName)
+			}
+		}
+	}
+
+	if isRental := query.Get("isRental"); isRental != "" {
+		value, err := strconv.ParseBool(isRental)
+		if err != nil {
+			return filter, errors.New("isRental must be true or false")
+		}
+		filter.IsRental = &value
+	}
+
+	filter.EndDateFrom, err = parseDate(query.Get("endDateFrom"))
+	if err != nil {
+		return filter, err
+	}
+
+	filter.EndDateTo, err = parseDate(query.Get("endDateTo"))
+	if err != nil {
+		return filter, err
+	}
+
+	if filter.MinDuration, err = parseDuration(query.Get("minDurationInMinutes")); err != nil {
+		return filter, err
+	}
+	if filter.MaxDuration, err = parseDuration(query.Get("maxDurationInMinutes")); err != nil {
+		return filter, err
+	}
+
+	if filter.StartTimeFrom, err = parseTimeOfDay(query.Get("startTimeFrom")); err != nil {
+		return filter, err
+	}
+	if filter.StartTimeTo, err = parseTimeOfDay(query.Get("startTimeTo")); err != nil {
+		return filter, err
+	}
+
+	filter.Search = strings.TrimSpace(query.Get("search"))
+
+	return filter, nil
+}
+
+func parseDuration(minutes string) (int, error) {
+	if minutes == "" {
+		return 0, nil
+	}
+	value, err := strconv.Atoi(minutes)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid duration %q", minutes)
+	}
+	return value, nil
+}
+
+// parseTimeOfDay reads an "HH:MM" wall clock time as it is stored in the task start time.
+func parseTimeOfDay(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	parsed, err := time.Parse("15:04", value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid time of day %q, expected HH:MM", value)
+	}
+	return &parsed, nil
+}
+
+const maxPageLimit = 1000
+
+// parsePage reads limit, cursor and sort, without a limit every matching task is returned.
+func parsePage(r *http.Request) (Page, error) {
+	var page Page
+	query := r.URL.Query()
+
+	if limit := query.Get("limit"); limit != "" {
+		var err error
+		page.Limit, err = strconv.Atoi(limit)
+		if err != nil || page.Limit <= 0 || page.Limit > maxPageLimit {
+			return page, fmt.Errorf("limit must be between 1 and %d", maxPageLimit)
+		}
+	}
+
+	if cursor := query.Get("cursor"); cursor != "" {
+		c, err := decodeCursor(cursor)
+		if err != nil {
+			return page, err
+		}
+		page.Cursor = &c
+	}
+
+	switch query.Get("sort") {
+	case "", "startDate":
+	case "-startDate":
+		page.Descending = true
+	default:
+		return page, errors.New("sort must be startDate or -startDate")
+	}
+
+	return page, nil
+}
+
+const (
+	cursorDateLayout = "2006-01-02"
+	cursorTimeLayout = "15:04:05.999999"
+)
+
+// encodeCursor keeps the wall clock values as stored, the cursor is opaque to clients.
+func encodeCursor(c Cursor) string {
+	raw := fmt.Sprintf("%s|%s|%d", c.TaskStartDate.Format(cursorDateLayout), c.TaskStartTime.Format(cursorTimeLayout), c.TaskID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(cursor string) (Cursor, error) {
+	var c Cursor
+	errInvalid := errors.New("invalid cursor")
+
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return c, errInvalid
+	}
+
+	parts := strings.Split(string(raw), "|")
+	if len(parts) != 3 {
+		return c, errInvalid
+	}
+	if c.TaskStartDate, err = time.Parse(cursorDateLayout, parts[0]); err != nil {
+		return c, errInvalid
+	}
+	if c.TaskStartTime, err = time.Parse(cursorTimeLayout, parts[1]); err != nil {
+		return c, errInvalid
+	}
+	if c.TaskID, err = strconv.Atoi(parts[2]); err != nil {
+		return c, errInvalid
+	}
+	return c, nil
+}
+
+// parseDate parses a calendar day of a filter. Task dates are wall clock values of the zone
+// each task is recorded in, so the day is compared as is, without any zone conversion.
 func parseDate(date string) (time.Time, error) {
 	var zeroDate time.Time
 	if date == "" {
@@ -104,30 +648,99 @@ func parseDate(date string) (time.Time, error) {
 	if err != nil {
 		return zeroDate, err
 	}
+	return parsedDate, nil
+}
 
-	loc, err := time.LoadLocation("Europe/Istanbul")
+// decodeCompletedTask decodes a task and converts its times into the zone of its branch.
+func decodeCompletedTask(body []byte, locate locator) (CompletedTask, error) {
+	var ct CompletedTask
+	err := json.Unmarshal(body, &ct)
 	if err != nil {
-		return zeroDate, fmt.Errorf("date&time conversion error: %w", err)
+		return ct, fmt.Errorf("decode error: %w", err)
 	}
 
-	return parsedDate.In(loc), nil
+	loc, err := locate(ct.CompanyName, ct.BranchName)
+	if err != nil {
+		return ct, fmt.Errorf("date&time conversion error: %w", err)
+	}
+	localizeCompletedTask(&ct, loc)
+
+	return ct, nil
 }
 
-func decodeCompletedTask(body []byte) (CompletedTask, error) {
-	var ct CompletedTask
-	err := json.Unmarshal(body, &ct)
-	if err != nil {
+// patchCompletedTask applies the fields present in body onto ct. The end date and time are
+// derived again from the start and duration unless the patch sets them itself.
+func patchCompletedTask(body []byte, ct CompletedTask, locate locator) (CompletedTask, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return ct, fmt.Errorf("decode error: %w", err)
+	}
+
+	var patch CompletedTask
+	if err := json.Unmarshal(body, &patch); err != nil {
 		return ct, fmt.Errorf("decode error: %w", err)
 	}
 
-	loc, err := time.LoadLocation("Europe/Istanbul")
+	// the patched times are read in the zone of the branch the task ends up in
+	companyName, branchName := ct.CompanyName, ct.BranchName
+	if _, ok := fields["companyName"]; ok {
+		companyName = patch.CompanyName
+	}
+	if _, ok := fields["branchName"]; ok {
+		branchName = patch.BranchName
+	}
+	loc, err := locate(companyName, branchName)
 	if err != nil {
 		return ct, fmt.Errorf("date&time conversion error: %w", err)
 	}
+	localizeCompletedTask(&patch, loc)
+	ct.TimeZone = patch.TimeZone
 
-	ct.TaskStartDate = ct.TaskStartDate.In(loc)
-	ct.TaskStartTime = ct.TaskStartTime.In(loc)
+	ct.TaskEndDate = time.Time{}
+	ct.TaskEndTime = time.Time{}
+	for field := range fields {
+		switch field {
+		case "id", "timeZone", "cost", "currency":
+		case "companyName":
+			ct.CompanyName = patch.CompanyName
+		case "branchName":
+			ct.BranchName = patch.BranchName
+		case "machineName":
+			ct.MachineName = patch.MachineName
+		case "taskStartDate":
+			ct.TaskStartDate = patch.TaskStartDate
+		case "taskStartTime":
+			ct.TaskStartTime = patch.TaskStartTime
+		case "taskStart":
+			ct.TaskStartDate = patch.TaskStartDate
+			ct.TaskStartTime = patch.TaskStartTime
+			ct.TaskStart = patch.TaskStart
+		case "taskEndDate":
+			ct.TaskEndDate = patch.TaskEndDate
+		case "taskEndTime":
+			ct.TaskEndTime = patch.TaskEndTime
+		case "taskEnd":
+			ct.TaskEndDate = patch.TaskEndDate
+			ct.TaskEndTime = patch.TaskEndTime
+		case "taskDurationInMinutes":
+			ct.TaskDurationInMinutes = patch.TaskDurationInMinutes
+		case "isRental":
+			ct.IsRental = patch.IsRental
+		case "taskDetail":
+			ct.TaskDetail = patch.TaskDetail
+		default:
+			return ct, fmt.Errorf("unknown field %q", field)
+		}
+	}
 
+	// a new end instant without a duration moves the end, so the duration follows it. The
+	// start is read from the patched split fields, the stored instant may be stale by now.
+	_, hasEnd := fields["taskEnd"]
+	_, hasDuration := fields["taskDurationInMinutes"]
+	if hasEnd && !hasDuration {
+		ct.TaskStart = wallClock(ct.TaskStartDate, ct.TaskStartTime, loc)
+		ct.TaskDurationInMinutes = int(patch.TaskEnd.Sub(ct.TaskStart).Minutes())
+	}
 	return ct, nil
 }
 
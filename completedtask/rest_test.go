@@ -0,0 +1,241 @@
+package completedtask
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+	"tzcnlr/auth"
+)
+
+func TestPatchCompletedTask(t *testing.T) {
+	istanbul, newYork := istanbul(t), loadZone(t, "America/New_York")
+	locate := func(companyName, branchName string) (*time.Location, error) {
+		switch branchName {
+		case "Merkez":
+			return istanbul, nil
+		case "Liman":
+			return newYork, nil
+		}
+		return nil, errors.New("branchName does not exist")
+	}
+
+	tests := []struct {
+		name         string
+		body         string
+		wantErr      bool
+		wantBranch   string
+		wantZone     string
+		wantStart    string
+		wantEnd      string
+		wantDuration int
+		wantDetail   string
+	}{
+		{
+			name:         "detail only",
+			body:         `{"taskDetail": "oil change", "id": 99}`,
+			wantBranch:   "Merkez",
+			wantZone:     "Europe/Istanbul",
+			wantStart:    "2024-03-01 08:30",
+			wantDuration: 90,
+			wantDetail:   "oil change",
+		},
+		{
+			name:         "duration clears the derived end",
+			body:         `{"taskDurationInMinutes": 120}`,
+			wantBranch:   "Merkez",
+			wantZone:     "Europe/Istanbul",
+			wantStart:    "2024-03-01 08:30",
+			wantDuration: 120,
+			wantDetail:   "lift",
+		},
+		{
+			name:         "end instant moves the duration",
+			body:         `{"taskEnd": "2024-03-01T09:00:00Z"}`,
+			wantBranch:   "Merkez",
+			wantZone:     "Europe/Istanbul",
+			wantStart:    "2024-03-01 08:30",
+			wantEnd:      "2024-03-01 12:00",
+			wantDuration: 210,
+			wantDetail:   "lift",
+		},
+		{
+			name:         "times are read in the zone of the new branch",
+			body:         `{"branchName": "Liman", "taskStartTime": "2024-03-01T13:00:00Z"}`,
+			wantBranch:   "Liman",
+			wantZone:     "America/New_York",
+			wantStart:    "2024-03-01 08:00",
+			wantDuration: 90,
+			wantDetail:   "lift",
+		},
+		{name: "unknown field", body: `{"taskName": "lift"}`, wantErr: true},
+		{name: "unknown branch", body: `{"branchName": "Depo"}`, wantErr: true},
+		{name: "not an object", body: `[]`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Date(2024, 3, 1, 8, 30, 0, 0, istanbul)
+			end := start.Add(90 * time.Minute)
+			stored := CompletedTask{
+				TaskID:                7,
+				CompanyName:           "Acme",
+				BranchName:            "Merkez",
+				MachineName:           "Vinç",
+				TaskStartDate:         start,
+				TaskStartTime:         start,
+				TaskEndDate:           end,
+				TaskEndTime:           end,
+				TaskDurationInMinutes: 90,
+				TaskDetail:            "lift",
+				TaskStart:             start,
+				TaskEnd:               end,
+				TimeZone:              "Europe/Istanbul",
+			}
+
+			ct, err := patchCompletedTask([]byte(tt.body), stored, locate)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error is %v, want an error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			if ct.TaskID != 7 || ct.BranchName != tt.wantBranch || ct.TimeZone != tt.wantZone || ct.TaskDetail != tt.wantDetail {
+				t.Errorf("patched to id %d, branch %q, zone %q, detail %q, want 7, %q, %q, %q", ct.TaskID, ct.BranchName, ct.TimeZone, ct.TaskDetail, tt.wantBranch, tt.wantZone, tt.wantDetail)
+			}
+			if got := wallClock(ct.TaskStartDate, ct.TaskStartTime, time.UTC).Format("2006-01-02 15:04"); got != tt.wantStart {
+				t.Errorf("start is %s, want %s", got, tt.wantStart)
+			}
+			gotEnd := ""
+			if !ct.TaskEndDate.IsZero() || !ct.TaskEndTime.IsZero() {
+				gotEnd = wallClock(ct.TaskEndDate, ct.TaskEndTime, time.UTC).Format("2006-01-02 15:04")
+			}
+			if gotEnd != tt.wantEnd {
+				t.Errorf("end is %q, want %q", gotEnd, tt.wantEnd)
+			}
+			if ct.TaskDurationInMinutes != tt.wantDuration {
+				t.Errorf("duration is %d, want %d", ct.TaskDurationInMinutes, tt.wantDuration)
+			}
+		})
+	}
+}
+
+func TestCursor(t *testing.T) {
+	c := Cursor{
+		TaskStartDate: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+		TaskStartTime: time.Date(0, 1, 1, 8, 30, 15, 250000000, time.UTC),
+		TaskID:        42,
+	}
+	got, err := decodeCursor(encodeCursor(c))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, c) {
+		t.Errorf("cursor is %+v, want %+v", got, c)
+	}
+
+	for _, cursor := range []string{"not base64!", "MjAyNC0wMy0wMXwwODozMA", "YXwwODozMDowMHw0Mg", "MjAyNC0wMy0wMXwwODozMDowMHx4"} {
+		if _, err := decodeCursor(cursor); err == nil {
+			t.Errorf("cursor %q is accepted", cursor)
+		}
+	}
+}
+
+func TestParsePage(t *testing.T) {
+	cursor := Cursor{TaskStartDate: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), TaskStartTime: time.Date(0, 1, 1, 8, 30, 0, 0, time.UTC), TaskID: 7}
+	tests := []struct {
+		name    string
+		query   string
+		want    Page
+		wantErr bool
+	}{
+		{name: "defaults", query: "", want: Page{}},
+		{name: "limit and descending", query: "limit=50&sort=-startDate", want: Page{Limit: 50, Descending: true}},
+		{name: "largest limit", query: "limit=1000&sort=startDate", want: Page{Limit: maxPageLimit}},
+		{name: "cursor", query: "cursor=" + encodeCursor(cursor), want: Page{Cursor: &cursor}},
+		{name: "zero limit", query: "limit=0", wantErr: true},
+		{name: "limit too large", query: "limit=1001", wantErr: true},
+		{name: "limit not a number", query: "limit=ten", wantErr: true},
+		{name: "bad cursor", query: "cursor=abc", wantErr: true},
+		{name: "unknown sort", query: "sort=machineName", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, err := parsePage(httptest.NewRequest("GET", "/completedTasks?"+tt.query, nil))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error is %v, want an error %v", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(page, tt.want) {
+				t.Errorf("page is %+v, want %+v", page, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCompletedTaskFilter(t *testing.T) {
+	yes := true
+	clock := func(hour, minute int) *time.Time {
+		c := time.Date(0, 1, 1, hour, minute, 0, 0, time.UTC)
+		return &c
+	}
+	tests := []struct {
+		name    string
+		scope   string
+		query   string
+		want    CompletedTaskFilter
+		wantErr bool
+	}{
+		{name: "no filter", query: ""},
+		{
+			name:  "every filter",
+			query: "companyName=Acme&branchName=Merkez&startDate=2024-03-01&endDate=2024-03-31&endDateFrom=2024-03-02&endDateTo=2024-04-01&isRental=true&minDurationInMinutes=30&maxDurationInMinutes=600&startTimeFrom=08:00&startTimeTo=17:30&search=+oil+",
+			want: CompletedTaskFilter{
+				CompanyName:   "Acme",
+				BranchName:    "Merkez",
+				StartDate:     time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+				EndDate:       time.Date(2024, 3, 31, 0, 0, 0, 0, time.UTC),
+				EndDateFrom:   time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC),
+				EndDateTo:     time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC),
+				IsRental:      &yes,
+				MinDuration:   30,
+				MaxDuration:   600,
+				StartTimeFrom: clock(8, 0),
+				StartTimeTo:   clock(17, 30),
+				Search:        "oil",
+			},
+		},
+		{
+			name:  "start time window over midnight",
+			query: "startTimeFrom=22:00&startTimeTo=06:00",
+			want:  CompletedTaskFilter{StartTimeFrom: clock(22, 0), StartTimeTo: clock(6, 0)},
+		},
+		{
+			name:  "machine names repeated and comma separated",
+			query: "machineName=Vinç,+Forklift&machineName=Kepçe&machineName=",
+			want:  CompletedTaskFilter{MachineNames: []string{"Vinç", "Forklift", "Kepçe"}},
+		},
+		{name: "company of the scope", scope: "Acme", query: "", want: CompletedTaskFilter{CompanyName: "Acme"}},
+		{name: "another company than the scope", scope: "Acme", query: "companyName=Globex", wantErr: true},
+		{name: "bad date", query: "startDate=01.03.2024", wantErr: true},
+		{name: "bad rental", query: "isRental=maybe", wantErr: true},
+		{name: "negative duration", query: "minDurationInMinutes=-5", wantErr: true},
+		{name: "bad time of day", query: "startTimeTo=25:00", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/completedTasks?"+tt.query, nil)
+			if tt.scope != "" {
+				r = r.WithContext(context.WithValue(r.Context(), "claims", auth.Claims{Role: "viewer", CompanyName: tt.scope}))
+			}
+			filter, err := parseCompletedTaskFilter(r)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error is %v, want an error %v", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(filter, tt.want) {
+				t.Errorf("filter is\n%+v\nwant\n%+v", filter, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package completedtask
+
+import (
+	"sync"
+	"time"
+)
+
+// locator returns the zone the tasks of a branch are recorded in.
+type locator func(companyName, branchName string) (*time.Location, error)
+
+// locations caches loaded zones, time.LoadLocation reads the zone database on every call.
+var locations sync.Map
+
+func loadLocation(name string) (*time.Location, error) {
+	if loc, ok := locations.Load(name); ok {
+		return loc.(*time.Location), nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, err
+	}
+	locations.Store(name, loc)
+	return loc, nil
+}
+
+// cachedLocator remembers the zone of every branch it has looked up, for requests touching
+// many tasks of the same few branches.
+func cachedLocator(locate locator) locator {
+	type branchKey struct{ companyName, branchName string }
+	cache := make(map[branchKey]*time.Location)
+	return func(companyName, branchName string) (*time.Location, error) {
+		key := branchKey{companyName, branchName}
+		if loc, ok := cache[key]; ok {
+			return loc, nil
+		}
+		loc, err := locate(companyName, branchName)
+		if err != nil {
+			return nil, err
+		}
+		cache[key] = loc
+		return loc, nil
+	}
+}
+
+// wallClock combines the calendar day of date and the clock of clock into an instant in loc.
+// Both are read as wall clock values, whatever zone they are labeled with. A clock repeated or
+// skipped by a transition of loc resolves like (date + time) AT TIME ZONE does in PostgreSQL,
+// so the instants agree with the completed_task_logs_instants_check constraint.
+func wallClock(date, clock time.Time, loc *time.Location) time.Time {
+	t := time.Date(date.Year(), date.Month(), date.Day(), clock.Hour(), clock.Minute(), clock.Second(), clock.Nanosecond(), loc)
+	wall := time.Date(date.Year(), date.Month(), date.Day(), clock.Hour(), clock.Minute(), clock.Second(), clock.Nanosecond(), time.UTC)
+
+	// time.Date picks either offset around a transition, PostgreSQL takes the smaller one, which
+	// is the offset after a fall back and the one before a spring forward
+	start, end := t.ZoneBounds()
+	for _, transition := range []time.Time{start, end} {
+		if transition.IsZero() {
+			continue
+		}
+		_, before := transition.Add(-time.Nanosecond).Zone()
+		_, after := transition.Zone()
+		earlier := wall.Add(-time.Duration(max(before, after)) * time.Second)
+		later := wall.Add(-time.Duration(min(before, after)) * time.Second)
+		if earlier.Before(transition) && !later.Before(transition) {
+			return later.In(loc)
+		}
+	}
+	return t
+}
+
+// sameDay reports whether a and b fall on the same calendar day of their own zones.
+func sameDay(a, b time.Time) bool {
+	ay, am, ad := a.Date()
+	by, bm, bd := b.Date()
+	return ay == by && am == bm && ad == bd
+}
+
+// sameClock reports whether a and b show the same time of day in their own zones.
+func sameClock(a, b time.Time) bool {
+	ah, am, as := a.Clock()
+	bh, bm, bs := b.Clock()
+	return ah == bh && am == bm && as == bs
+}
+
+// localizeCompletedTask converts the instants of a decoded task into wall clock values of loc,
+// the zone the task is recorded in.
+func localizeCompletedTask(ct *CompletedTask, loc *time.Location) {
+	ct.TimeZone = loc.String()
+	ct.TaskStartDate = ct.TaskStartDate.In(loc)
+	ct.TaskStartTime = ct.TaskStartTime.In(loc)
+	ct.TaskEndDate = ct.TaskEndDate.In(loc)
+	ct.TaskEndTime = ct.TaskEndTime.In(loc)
+	applyTaskInstants(ct, loc)
+}
+
+// applyTaskInstants fills the split date and time fields from the taskStart and taskEnd
+// instants when they are given, the instants win over the split fields. Without an explicit
+// duration it is taken from the distance between the two instants.
+func applyTaskInstants(ct *CompletedTask, loc *time.Location) {
+	if !ct.TaskStart.IsZero() {
+		ct.TaskStartDate = ct.TaskStart.In(loc)
+		ct.TaskStartTime = ct.TaskStart.In(loc)
+	}
+	if !ct.TaskEnd.IsZero() {
+		ct.TaskEndDate = ct.TaskEnd.In(loc)
+		ct.TaskEndTime = ct.TaskEnd.In(loc)
+		if ct.TaskDurationInMinutes == 0 && !ct.TaskStart.IsZero() {
+			ct.TaskDurationInMinutes = int(ct.TaskEnd.Sub(ct.TaskStart).Minutes())
+		}
+	}
+}
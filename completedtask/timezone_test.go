@@ -0,0 +1,39 @@
+package completedtask
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWallClock(t *testing.T) {
+	tests := []struct {
+		name string
+		zone string
+		wall string
+		want string
+	}{
+		{name: "plain", zone: "America/New_York", wall: "2024-07-01 08:30", want: "2024-07-01T12:30:00Z"},
+		{name: "repeated after a fall back", zone: "America/New_York", wall: "2024-11-03 01:30", want: "2024-11-03T06:30:00Z"},
+		{name: "just before a fall back", zone: "America/New_York", wall: "2024-11-03 00:59", want: "2024-11-03T04:59:00Z"},
+		{name: "just after a fall back", zone: "America/New_York", wall: "2024-11-03 02:00", want: "2024-11-03T07:00:00Z"},
+		{name: "skipped by a spring forward", zone: "America/New_York", wall: "2024-03-10 02:30", want: "2024-03-10T07:30:00Z"},
+		{name: "repeated east of utc", zone: "Europe/Berlin", wall: "2024-10-27 02:30", want: "2024-10-27T01:30:00Z"},
+		{name: "skipped east of utc", zone: "Europe/Berlin", wall: "2024-03-31 02:30", want: "2024-03-31T01:30:00Z"},
+		{name: "zone without transitions", zone: "Europe/Istanbul", wall: "2024-11-03 01:30", want: "2024-11-02T22:30:00Z"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loc, err := time.LoadLocation(tt.zone)
+			if err != nil {
+				t.Fatal(err)
+			}
+			wall, err := time.Parse("2006-01-02 15:04", tt.wall)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := wallClock(wall, wall, loc).UTC().Format(time.RFC3339); got != tt.want {
+				t.Errorf("%s in %s is %s, want %s", tt.wall, tt.zone, got, tt.want)
+			}
+		})
+	}
+}
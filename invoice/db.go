@@ -0,0 +1,228 @@
+package invoice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"time"
+)
+
+var ErrInvoiceNotFound = errors.New("invoice does not exist")
+
+var ErrUnknownCompany = errors.New("company does not exist")
+
+type InvoiceDB struct {
+	db *pgxpool.Pool
+}
+
+func NewInvoiceDB(db *pgxpool.Pool) *InvoiceDB {
+	return &InvoiceDB{
+		db: db,
+	}
+}
+
+// CreateInvoice stores the invoice price makes of the uninvoiced tasks of draft's company in
+// its period. Invoices of the same company are issued one at a time, and the tasks stay locked
+// from being changed until they are marked as invoiced.
+func (i *InvoiceDB) CreateInvoice(draft Invoice, year int, price func([]billableTask) (Invoice, error)) (Invoice, error) {
+	ctx := context.Background()
+	tx, err := i.db.Begin(ctx)
+	if err != nil {
+		return draft, err
+	}
+	defer tx.Rollback(ctx)
+
+	var companyID int
+	err = tx.QueryRow(ctx, "SELECT company_id FROM company WHERE company_name = $1", draft.CompanyName).Scan(&companyID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return draft, ErrUnknownCompany
+	}
+	if err != nil {
+		return draft, err
+	}
+
+	if _, err = tx.Exec(ctx, "SELECT pg_advisory_xact_lock(hashtext('invoice:' || $1::text))", companyID); err != nil {
+		return draft, err
+	}
+
+	tasks, err := getBillableTasks(ctx, tx, companyID, draft.PeriodStart, draft.PeriodEnd)
+	if err != nil {
+		return draft, err
+	}
+	inv, err := price(tasks)
+	if err != nil {
+		return draft, err
+	}
+
+	// the counter row stays locked until commit, so numbers are handed out without gaps
+	var number int
+	query := `
+	INSERT INTO invoice_number_counter (year, last_number) VALUES ($1, 1)
+	ON CONFLICT (year) DO UPDATE SET last_number = invoice_number_counter.last_number + 1
+	RETURNING last_number`
+	if err = tx.QueryRow(ctx, query, year).Scan(&number); err != nil {
+		return draft, err
+	}
+	inv.InvoiceNumber = fmt.Sprintf("%d-%06d", year, number)
+
+	query = `
+	INSERT INTO invoice (invoice_number, company_id, company_name, period_start, period_end, currency, vat_rate, net_amount, vat_amount, total_amount, issued_by)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+	RETURNING invoice_id, issued_at`
+	err = tx.QueryRow(ctx, query,
+		inv.InvoiceNumber,
+		companyID,
+		inv.CompanyName,
+		inv.PeriodStart,
+		inv.PeriodEnd,
+		inv.Currency,
+		inv.VATRate,
+		inv.NetAmount,
+		inv.VATAmount,
+		inv.TotalAmount,
+		inv.IssuedBy,
+	).Scan(&inv.InvoiceID, &inv.IssuedAt)
+	if err != nil {
+		return draft, err
+	}
+
+	for _, line := range inv.Lines {
+		query = `
+		INSERT INTO invoice_line (invoice_id, line_no, branch_name, machine_name, is_rental, minutes, unit_rate, amount)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+		_, err = tx.Exec(ctx, query, inv.InvoiceID, line.LineNo, line.BranchName, line.MachineName, line.IsRental, line.minutes, line.UnitRate, line.Amount)
+		if err != nil {
+			return draft, err
+		}
+
+		query = "INSERT INTO invoice_task (task_id, invoice_id, line_no) SELECT unnest($1::int[]), $2, $3"
+		if _, err = tx.Exec(ctx, query, line.TaskIDs, inv.InvoiceID, line.LineNo); err != nil {
+			return draft, err
+		}
+	}
+
+	return inv, tx.Commit(ctx)
+}
+
+// getBillableTasks reads and locks the uninvoiced tasks of the company started in [from, to),
+// with the rates of the rate card valid for each task.
+func getBillableTasks(ctx context.Context, tx pgx.Tx, companyID int, from, to time.Time) ([]billableTask, error) {
+	query := `
+	SELECT t.task_id, b.branch_name, m.machine_name, t.is_rental, t.task_duration_in_minutes, r.hourly_rate, r.daily_rate, r.currency
+	FROM completed_task_logs t
+	JOIN branch b ON b.branch_id = t.branch_id
+	JOIN machine m ON m.machine_id = t.machine_id
+	LEFT JOIN LATERAL (
+		SELECT rc.hourly_rate, rc.daily_rate, rc.currency FROM rate_card rc
+		WHERE rc.machine_id = t.machine_id AND (rc.company_id = t.company_id OR rc.company_id IS NULL)
+			AND rc.valid_from <= t.task_start_date AND (rc.valid_until IS NULL OR t.task_start_date < rc.valid_until)
+		ORDER BY rc.company_id NULLS LAST
+		LIMIT 1
+	) r ON true
+	WHERE t.company_id = $1 AND t.task_start_date >= $2 AND t.task_start_date < $3
+		AND NOT EXISTS (SELECT 1 FROM invoice_task it WHERE it.task_id = t.task_id)
+	ORDER BY t.task_start, t.task_id
+	FOR UPDATE OF t`
+
+	rows, err := tx.Query(ctx, query, companyID, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+	var tasks []billableTask
+	for rows.Next() {
+		var task billableTask
+		err := rows.Scan(&task.TaskID, &task.BranchName, &task.MachineName, &task.IsRental, &task.Minutes, &task.HourlyRate, &task.DailyRate, &task.Currency)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks, rows.Err()
+}
+
+const invoiceColumns = "i.invoice_id, i.invoice_number, i.company_name, c.company_name, i.period_start, i.period_end, i.currency, i.vat_rate, i.net_amount, i.vat_amount, i.total_amount, i.issued_by, i.issued_at"
+
+const invoiceTables = "invoice i JOIN company c ON c.company_id = i.company_id"
+
+func scanInvoice(row pgx.Row) (Invoice, error) {
+	var inv Invoice
+	err := row.Scan(
+		&inv.InvoiceID,
+		&inv.InvoiceNumber,
+		&inv.CompanyName,
+		&inv.currentCompanyName,
+		&inv.PeriodStart,
+		&inv.PeriodEnd,
+		&inv.Currency,
+		&inv.VATRate,
+		&inv.NetAmount,
+		&inv.VATAmount,
+		&inv.TotalAmount,
+		&inv.IssuedBy,
+		&inv.IssuedAt)
+	return inv, err
+}
+
+func (i *InvoiceDB) GetInvoiceByID(invoiceID int) (Invoice, error) {
+	ctx := context.Background()
+	inv, err := scanInvoice(i.db.QueryRow(ctx, "SELECT "+invoiceColumns+" FROM "+invoiceTables+" WHERE i.invoice_id = $1", invoiceID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return inv, ErrInvoiceNotFound
+	}
+	if err != nil {
+		return inv, err
+	}
+
+	query := `
+	SELECT l.line_no, l.branch_name, l.machine_name, l.is_rental, l.minutes, l.unit_rate, l.amount,
+		ARRAY(SELECT task_id FROM invoice_task it WHERE it.invoice_id = l.invoice_id AND it.line_no = l.line_no ORDER BY task_id)
+	FROM invoice_line l
+	WHERE l.invoice_id = $1
+	ORDER BY l.line_no`
+
+	rows, err := i.db.Query(ctx, query, invoiceID)
+	if err != nil {
+		return inv, err
+	}
+
+	defer rows.Close()
+	for rows.Next() {
+		var line InvoiceLine
+		err := rows.Scan(&line.LineNo, &line.BranchName, &line.MachineName, &line.IsRental, &line.minutes, &line.UnitRate, &line.Amount, &line.TaskIDs)
+		if err != nil {
+			return inv, err
+		}
+		line.setQuantity()
+		inv.Lines = append(inv.Lines, line)
+	}
+	return inv, rows.Err()
+}
+
+func (i *InvoiceDB) GetInvoices(companyName string) ([]Invoice, error) {
+	query := "SELECT " + invoiceColumns + " FROM " + invoiceTables + " WHERE ($1 = '' OR c.company_name = $1) ORDER BY i.invoice_id DESC"
+
+	rows, err := i.db.Query(context.Background(), query, companyName)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+	var invoices []Invoice
+	for rows.Next() {
+		inv, err := scanInvoice(rows)
+		if err != nil {
+			return nil, err
+		}
+		invoices = append(invoices, inv)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return invoices, nil
+}
@@ -0,0 +1,249 @@
+package invoice
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultVATRate is the VAT percentage used when a request does not name one.
+const DefaultVATRate = 20
+
+const (
+	UnitHour = "hour"
+	UnitDay  = "day"
+)
+
+var ErrNoBillableTasks = errors.New("company has no uninvoiced tasks in the period")
+
+var ErrCurrencyMismatch = errors.New("tasks of the invoice are priced in different currencies")
+
+var ErrCurrencyMissing = errors.New("currency not provided and no rate card applies to the tasks")
+
+// UnpricedError is returned when neither the request nor a rate card gives a rate for tasks.
+type UnpricedError struct {
+	TaskIDs []int
+}
+
+func (e *UnpricedError) Error() string {
+	ids := make([]string, len(e.TaskIDs))
+	for i, id := range e.TaskIDs {
+		ids[i] = strconv.Itoa(id)
+	}
+	return "no rate for completed tasks " + strings.Join(ids, ", ")
+}
+
+// Invoice bills the tasks a company started in [PeriodStart, PeriodEnd). Amounts are in minor
+// units of Currency, VATRate is a percentage.
+type Invoice struct {
+	InvoiceID     int           `json:"id"`
+	InvoiceNumber string        `json:"invoiceNumber"`
+	CompanyName   string        `json:"companyName"`
+	PeriodStart   time.Time     `json:"periodStart"`
+	PeriodEnd     time.Time     `json:"periodEnd"`
+	Currency      string        `json:"currency"`
+	VATRate       int           `json:"vatRate"`
+	NetAmount     int64         `json:"netAmount"`
+	VATAmount     int64         `json:"vatAmount"`
+	TotalAmount   int64         `json:"totalAmount"`
+	IssuedBy      string        `json:"issuedBy"`
+	IssuedAt      time.Time     `json:"issuedAt"`
+	Lines         []InvoiceLine `json:"lines,omitempty"`
+	// currentCompanyName is the name of the company now, CompanyName the one it was invoiced as
+	currentCompanyName string
+}
+
+// InvoiceLine sums the tasks of one machine at one branch billed at the same unit rate.
+// Quantity is in hours for service tasks and in days for rentals, as told by Unit.
+type InvoiceLine struct {
+	LineNo      int     `json:"lineNo"`
+	BranchName  string  `json:"branchName"`
+	MachineName string  `json:"machineName"`
+	IsRental    bool    `json:"isRental"`
+	Quantity    float64 `json:"quantity"`
+	Unit        string  `json:"unit"`
+	UnitRate    int64   `json:"unitRate"`
+	Amount      int64   `json:"amount"`
+	TaskIDs     []int   `json:"taskIds"`
+	// minutes is the billed time, Quantity is derived from it
+	minutes int
+}
+
+// InvoiceRequest asks for the invoice of a company for the calendar month Month, like 2024-03.
+// HourlyRate and DailyRate price every service task and rental of the invoice, the rate cards
+// of the machines are used where they are not set.
+type InvoiceRequest struct {
+	CompanyName string `json:"companyName"`
+	Month       string `json:"month"`
+	HourlyRate  *int64 `json:"hourlyRate"`
+	DailyRate   *int64 `json:"dailyRate"`
+	Currency    string `json:"currency"`
+	VATRate     *int   `json:"vatRate"`
+}
+
+// billableTask is an uninvoiced task with the rates of the rate card valid for it, if any.
+type billableTask struct {
+	TaskID      int
+	BranchName  string
+	MachineName string
+	IsRental    bool
+	Minutes     int
+	HourlyRate  *int64
+	DailyRate   *int64
+	Currency    *string
+}
+
+type InvoiceService struct {
+	iDB      *InvoiceDB
+	location *time.Location
+}
+
+// NewInvoiceService numbers invoices by the year they are issued in location.
+func NewInvoiceService(iDB *InvoiceDB, location *time.Location) *InvoiceService {
+	return &InvoiceService{
+		iDB:      iDB,
+		location: location,
+	}
+}
+
+// CreateInvoice issues the invoice of request over every task of the company in the period
+// that is not invoiced yet. The tasks are locked against changes from then on.
+func (s *InvoiceService) CreateInvoice(request InvoiceRequest, periodStart, periodEnd time.Time, issuedBy string) (Invoice, error) {
+	vatRate := DefaultVATRate
+	if request.VATRate != nil {
+		vatRate = *request.VATRate
+	}
+
+	draft := Invoice{
+		CompanyName: request.CompanyName,
+		PeriodStart: periodStart,
+		PeriodEnd:   periodEnd,
+		VATRate:     vatRate,
+		IssuedBy:    issuedBy,
+	}
+	year := time.Now().In(s.location).Year()
+	return s.iDB.CreateInvoice(draft, year, func(tasks []billableTask) (Invoice, error) {
+		return priceInvoice(draft, tasks, request)
+	})
+}
+
+func (s *InvoiceService) GetInvoiceByID(invoiceID int) (Invoice, error) {
+	return s.iDB.GetInvoiceByID(invoiceID)
+}
+
+// GetInvoices lists the invoices of the company now named companyName, or of every company if
+// it is empty, without their lines.
+func (s *InvoiceService) GetInvoices(companyName string) ([]Invoice, error) {
+	return s.iDB.GetInvoices(companyName)
+}
+
+// priceInvoice fills the lines and amounts of inv from tasks.
+func priceInvoice(inv Invoice, tasks []billableTask, request InvoiceRequest) (Invoice, error) {
+	if len(tasks) == 0 {
+		return inv, ErrNoBillableTasks
+	}
+
+	type lineKey struct {
+		branchName  string
+		machineName string
+		isRental    bool
+		unitRate    int64
+	}
+	lines := make(map[lineKey]*InvoiceLine)
+	currency := request.Currency
+	var unpriced []int
+	for _, task := range tasks {
+		requestRate, cardRate := request.HourlyRate, task.HourlyRate
+		if task.IsRental {
+			requestRate, cardRate = request.DailyRate, task.DailyRate
+		}
+
+		var unitRate int64
+		switch {
+		case requestRate != nil:
+			unitRate = *requestRate
+		case cardRate != nil:
+			unitRate = *cardRate
+			if currency == "" {
+				currency = *task.Currency
+			} else if currency != *task.Currency {
+				return inv, ErrCurrencyMismatch
+			}
+		default:
+			unpriced = append(unpriced, task.TaskID)
+			continue
+		}
+
+		key := lineKey{task.BranchName, task.MachineName, task.IsRental, unitRate}
+		line, ok := lines[key]
+		if !ok {
+			line = &InvoiceLine{
+				BranchName:  task.BranchName,
+				MachineName: task.MachineName,
+				IsRental:    task.IsRental,
+				UnitRate:    unitRate,
+			}
+			lines[key] = line
+		}
+		line.minutes += task.Minutes
+		line.TaskIDs = append(line.TaskIDs, task.TaskID)
+	}
+	if len(unpriced) > 0 {
+		return inv, &UnpricedError{TaskIDs: unpriced}
+	}
+	if currency == "" {
+		return inv, ErrCurrencyMissing
+	}
+	inv.Currency = currency
+
+	inv.Lines = make([]InvoiceLine, 0, len(lines))
+	for _, line := range lines {
+		line.setAmount()
+		inv.Lines = append(inv.Lines, *line)
+	}
+	sort.Slice(inv.Lines, func(i, j int) bool {
+		a, b := inv.Lines[i], inv.Lines[j]
+		if a.BranchName != b.BranchName {
+			return a.BranchName < b.BranchName
+		}
+		if a.MachineName != b.MachineName {
+			return a.MachineName < b.MachineName
+		}
+		if a.IsRental != b.IsRental {
+			return !a.IsRental
+		}
+		return a.UnitRate < b.UnitRate
+	})
+
+	inv.NetAmount = 0
+	for i := range inv.Lines {
+		inv.Lines[i].LineNo = i + 1
+		inv.NetAmount += inv.Lines[i].Amount
+	}
+	inv.VATAmount = (inv.NetAmount*int64(inv.VATRate) + 50) / 100
+	inv.TotalAmount = inv.NetAmount + inv.VATAmount
+	return inv, nil
+}
+
+// setAmount prices the billed minutes. Rentals only come in whole days, service time is
+// charged pro rata and rounded half up to the minor unit.
+func (line *InvoiceLine) setAmount() {
+	line.setQuantity()
+	if line.IsRental {
+		line.Amount = line.UnitRate * int64(line.minutes/1440)
+		return
+	}
+	line.Amount = (line.UnitRate*int64(line.minutes) + 30) / 60
+}
+
+func (line *InvoiceLine) setQuantity() {
+	if line.IsRental {
+		line.Quantity = float64(line.minutes / 1440)
+		line.Unit = UnitDay
+		return
+	}
+	line.Quantity = float64(line.minutes) / 60
+	line.Unit = UnitHour
+}
@@ -0,0 +1,193 @@
+package invoice
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func rate(r int64) *int64 { return &r }
+
+func currency(c string) *string { return &c }
+
+func TestPriceInvoiceLines(t *testing.T) {
+	tasks := []billableTask{
+		{TaskID: 1, BranchName: "Merkez", MachineName: "Vinç", Minutes: 90, HourlyRate: rate(6000), DailyRate: rate(100000), Currency: currency("TRY")},
+		{TaskID: 2, BranchName: "Merkez", MachineName: "Vinç", IsRental: true, Minutes: 2 * 1440, HourlyRate: rate(6000), DailyRate: rate(100000), Currency: currency("TRY")},
+		{TaskID: 3, BranchName: "Merkez", MachineName: "Vinç", Minutes: 30, HourlyRate: rate(6000), DailyRate: rate(100000), Currency: currency("TRY")},
+		{TaskID: 4, BranchName: "Merkez", MachineName: "Vinç", Minutes: 60, HourlyRate: rate(4000), Currency: currency("TRY")},
+		{TaskID: 5, BranchName: "Liman", MachineName: "Vinç", Minutes: 60, HourlyRate: rate(6000), Currency: currency("TRY")},
+		{TaskID: 6, BranchName: "Merkez", MachineName: "Forklift", Minutes: 120, HourlyRate: rate(3000), Currency: currency("TRY")},
+	}
+
+	inv, err := priceInvoice(Invoice{VATRate: 20}, tasks, InvoiceRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// lines are sorted by branch and machine, service before rental and then by rate
+	want := []InvoiceLine{
+		{LineNo: 1, BranchName: "Liman", MachineName: "Vinç", Quantity: 1, Unit: UnitHour, UnitRate: 6000, Amount: 6000, TaskIDs: []int{5}, minutes: 60},
+		{LineNo: 2, BranchName: "Merkez", MachineName: "Forklift", Quantity: 2, Unit: UnitHour, UnitRate: 3000, Amount: 6000, TaskIDs: []int{6}, minutes: 120},
+		{LineNo: 3, BranchName: "Merkez", MachineName: "Vinç", Quantity: 1, Unit: UnitHour, UnitRate: 4000, Amount: 4000, TaskIDs: []int{4}, minutes: 60},
+		{LineNo: 4, BranchName: "Merkez", MachineName: "Vinç", Quantity: 2, Unit: UnitHour, UnitRate: 6000, Amount: 12000, TaskIDs: []int{1, 3}, minutes: 120},
+		{LineNo: 5, BranchName: "Merkez", MachineName: "Vinç", IsRental: true, Quantity: 2, Unit: UnitDay, UnitRate: 100000, Amount: 200000, TaskIDs: []int{2}, minutes: 2 * 1440},
+	}
+	if !reflect.DeepEqual(inv.Lines, want) {
+		t.Errorf("lines are\n%+v\nwant\n%+v", inv.Lines, want)
+	}
+	if inv.Currency != "TRY" {
+		t.Errorf("currency is %q, want TRY", inv.Currency)
+	}
+	if inv.NetAmount != 228000 || inv.VATAmount != 45600 || inv.TotalAmount != 273600 {
+		t.Errorf("net, vat and total are %d, %d, %d, want 228000, 45600, 273600", inv.NetAmount, inv.VATAmount, inv.TotalAmount)
+	}
+}
+
+func TestPriceInvoiceRounding(t *testing.T) {
+	tests := []struct {
+		name       string
+		task       billableTask
+		vatRate    int
+		wantAmount int64
+		wantVAT    int64
+	}{
+		{name: "service rounds half up", task: billableTask{Minutes: 1, HourlyRate: rate(90)}, vatRate: 0, wantAmount: 2},
+		{name: "service rounds down below half", task: billableTask{Minutes: 1, HourlyRate: rate(89)}, vatRate: 0, wantAmount: 1},
+		{name: "service pro rata", task: billableTask{Minutes: 1, HourlyRate: rate(1000)}, vatRate: 0, wantAmount: 17},
+		{name: "rental bills whole days only", task: billableTask{IsRental: true, Minutes: 3*1440 - 1, DailyRate: rate(5000)}, vatRate: 0, wantAmount: 10000},
+		{name: "rental shorter than a day", task: billableTask{IsRental: true, Minutes: 600, DailyRate: rate(5000)}, vatRate: 0, wantAmount: 0},
+		{name: "vat rounds half up", task: billableTask{Minutes: 60, HourlyRate: rate(25)}, vatRate: 10, wantAmount: 25, wantVAT: 3},
+		{name: "vat rounds down below half", task: billableTask{Minutes: 60, HourlyRate: rate(24)}, vatRate: 10, wantAmount: 24, wantVAT: 2},
+		{name: "vat of the default rate", task: billableTask{Minutes: 60, HourlyRate: rate(12345)}, vatRate: DefaultVATRate, wantAmount: 12345, wantVAT: 2469},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.task.TaskID = 1
+			tt.task.Currency = currency("TRY")
+			inv, err := priceInvoice(Invoice{VATRate: tt.vatRate}, []billableTask{tt.task}, InvoiceRequest{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if inv.NetAmount != tt.wantAmount {
+				t.Errorf("net amount is %d, want %d", inv.NetAmount, tt.wantAmount)
+			}
+			if inv.VATAmount != tt.wantVAT {
+				t.Errorf("vat amount is %d, want %d", inv.VATAmount, tt.wantVAT)
+			}
+			if inv.TotalAmount != tt.wantAmount+tt.wantVAT {
+				t.Errorf("total amount is %d, want %d", inv.TotalAmount, tt.wantAmount+tt.wantVAT)
+			}
+		})
+	}
+}
+
+func TestPriceInvoiceRates(t *testing.T) {
+	tests := []struct {
+		name         string
+		tasks        []billableTask
+		request      InvoiceRequest
+		wantCurrency string
+		wantNet      int64
+		wantErr      error
+		wantUnpriced []int
+	}{
+		{
+			name:    "no tasks",
+			wantErr: ErrNoBillableTasks,
+		},
+		{
+			name:         "request rates win over the cards",
+			tasks:        []billableTask{{TaskID: 1, Minutes: 60, HourlyRate: rate(1000), Currency: currency("TRY")}},
+			request:      InvoiceRequest{HourlyRate: rate(500), Currency: "EUR"},
+			wantCurrency: "EUR",
+			wantNet:      500,
+		},
+		{
+			name: "cards price what the request does not",
+			tasks: []billableTask{
+				{TaskID: 1, Minutes: 60, HourlyRate: rate(1000), Currency: currency("TRY")},
+				{TaskID: 2, IsRental: true, Minutes: 1440, DailyRate: rate(7000), Currency: currency("TRY")},
+			},
+			request:      InvoiceRequest{HourlyRate: rate(500)},
+			wantCurrency: "TRY",
+			wantNet:      7500,
+		},
+		{
+			name: "cards in different currencies",
+			tasks: []billableTask{
+				{TaskID: 1, Minutes: 60, HourlyRate: rate(1000), Currency: currency("TRY")},
+				{TaskID: 2, Minutes: 60, HourlyRate: rate(1000), Currency: currency("EUR")},
+			},
+			wantErr: ErrCurrencyMismatch,
+		},
+		{
+			name:    "card in another currency than the request",
+			tasks:   []billableTask{{TaskID: 1, Minutes: 60, HourlyRate: rate(1000), Currency: currency("TRY")}},
+			request: InvoiceRequest{Currency: "EUR"},
+			wantErr: ErrCurrencyMismatch,
+		},
+		{
+			name:    "request rates without a currency",
+			tasks:   []billableTask{{TaskID: 1, Minutes: 60}},
+			request: InvoiceRequest{HourlyRate: rate(500)},
+			wantErr: ErrCurrencyMissing,
+		},
+		{
+			name: "tasks without any rate",
+			tasks: []billableTask{
+				{TaskID: 1, Minutes: 60, HourlyRate: rate(1000), Currency: currency("TRY")},
+				{TaskID: 2, Minutes: 60},
+				{TaskID: 3, IsRental: true, Minutes: 1440, HourlyRate: rate(1000), Currency: currency("TRY")},
+			},
+			wantUnpriced: []int{2, 3},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inv, err := priceInvoice(Invoice{}, tt.tasks, tt.request)
+			if tt.wantUnpriced != nil {
+				var unpricedErr *UnpricedError
+				if !errors.As(err, &unpricedErr) || !reflect.DeepEqual(unpricedErr.TaskIDs, tt.wantUnpriced) {
+					t.Fatalf("error is %v, want tasks %v unpriced", err, tt.wantUnpriced)
+				}
+				return
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error is %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if inv.Currency != tt.wantCurrency {
+				t.Errorf("currency is %q, want %q", inv.Currency, tt.wantCurrency)
+			}
+			if inv.NetAmount != tt.wantNet {
+				t.Errorf("net amount is %d, want %d", inv.NetAmount, tt.wantNet)
+			}
+		})
+	}
+}
+
+func TestFormatAmount(t *testing.T) {
+	tests := []struct {
+		amount   int64
+		currency string
+		want     string
+	}{
+		{123456, "TRY", "1234.56"},
+		{5, "EUR", "0.05"},
+		{-5, "EUR", "-0.05"},
+		{0, "USD", "0.00"},
+		{123456, "JPY", "123456"},
+		{-7, "KRW", "-7"},
+		{123456, "KWD", "123.456"},
+		{5, "BHD", "0.005"},
+		{123456, "CLF", "12.3456"},
+	}
+	for _, tt := range tests {
+		if got := formatAmount(tt.amount, tt.currency); got != tt.want {
+			t.Errorf("formatAmount(%d, %q) is %q, want %q", tt.amount, tt.currency, got, tt.want)
+		}
+	}
+}
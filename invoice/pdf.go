@@ -0,0 +1,188 @@
+package invoice
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+// The invoice is typeset in Courier, one of the standard fonts every PDF reader has, so no font
+// has to be embedded and the columns line up by padding alone.
+const (
+	pdfPageWidth    = 595 // A4 in points
+	pdfPageHeight   = 842
+	pdfMargin       = 50
+	pdfFontSize     = 9
+	pdfLeading      = 13
+	pdfLinesPerPage = (pdfPageHeight - 2*pdfMargin) / pdfLeading
+)
+
+// pdfTransliteration replaces letters WinAnsiEncoding lacks, mostly Turkish ones.
+var pdfTransliteration = map[rune]string{
+	'ğ': "g", 'Ğ': "G", 'ı': "i", 'İ': "I", 'ş': "s", 'Ş': "S",
+}
+
+// WritePDF renders inv as a PDF document.
+func WritePDF(w io.Writer, inv Invoice) error {
+	lines := invoiceText(inv)
+	var pages [][]string
+	for len(lines) > pdfLinesPerPage {
+		pages = append(pages, lines[:pdfLinesPerPage])
+		lines = lines[pdfLinesPerPage:]
+	}
+	pages = append(pages, lines)
+	return writePDF(w, pages)
+}
+
+func invoiceText(inv Invoice) []string {
+	lines := []string{
+		"INVOICE " + inv.InvoiceNumber,
+		"",
+		"Customer:  " + inv.CompanyName,
+		"Period:    " + inv.PeriodStart.Format("2006-01-02") + " - " + inv.PeriodEnd.AddDate(0, 0, -1).Format("2006-01-02"),
+		"Issued:    " + inv.IssuedAt.Format("2006-01-02"),
+		"Currency:  " + inv.Currency,
+		"",
+		fmt.Sprintf("%-3s %-18s %-18s %-8s %8s %-4s %9s %12s", "#", "Branch", "Machine", "Type", "Qty", "Unit", "Rate", "Amount"),
+		strings.Repeat("-", 87),
+	}
+	for _, line := range inv.Lines {
+		kind := "service"
+		if line.IsRental {
+			kind = "rental"
+		}
+		lines = append(lines, fmt.Sprintf("%-3d %-18s %-18s %-8s %8s %-4s %9s %12s",
+			line.LineNo,
+			truncate(line.BranchName, 18),
+			truncate(line.MachineName, 18),
+			kind,
+			formatQuantity(line.Quantity),
+			line.Unit,
+			formatAmount(line.UnitRate, inv.Currency),
+			formatAmount(line.Amount, inv.Currency)))
+	}
+	lines = append(lines,
+		strings.Repeat("-", 87),
+		fmt.Sprintf("%74s %12s", "Net", formatAmount(inv.NetAmount, inv.Currency)),
+		fmt.Sprintf("%74s %12s", fmt.Sprintf("VAT %d%%", inv.VATRate), formatAmount(inv.VATAmount, inv.Currency)),
+		fmt.Sprintf("%74s %12s", "Total "+inv.Currency, formatAmount(inv.TotalAmount, inv.Currency)),
+	)
+	return lines
+}
+
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n-1]) + "~"
+}
+
+func formatQuantity(quantity float64) string {
+	if quantity == float64(int64(quantity)) {
+		return fmt.Sprintf("%d", int64(quantity))
+	}
+	return fmt.Sprintf("%.1f", quantity)
+}
+
+// currencyExponents holds the ISO 4217 minor unit digits of the currencies that do not have two.
+var currencyExponents = map[string]int{
+	"BIF": 0, "CLP": 0, "DJF": 0, "GNF": 0, "ISK": 0, "JPY": 0, "KMF": 0, "KRW": 0, "PYG": 0,
+	"RWF": 0, "UGX": 0, "UYI": 0, "VND": 0, "VUV": 0, "XAF": 0, "XOF": 0, "XPF": 0,
+	"BHD": 3, "IQD": 3, "JOD": 3, "KWD": 3, "LYD": 3, "OMR": 3, "TND": 3,
+	"CLF": 4, "UYW": 4,
+}
+
+// formatAmount prints minor units of currency with as many decimals as the currency has.
+func formatAmount(amount int64, currency string) string {
+	exponent, ok := currencyExponents[currency]
+	if !ok {
+		exponent = 2
+	}
+
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	if exponent == 0 {
+		return fmt.Sprintf("%s%d", sign, amount)
+	}
+	unit := int64(math.Pow10(exponent))
+	return fmt.Sprintf("%s%d.%0*d", sign, amount/unit, exponent, amount%unit)
+}
+
+// writePDF writes a document with one page per element of pages, each a list of text lines.
+// Objects 1 to 3 are the catalog, the page tree and the font, every page adds a page object
+// and its content stream.
+func writePDF(w io.Writer, pages [][]string) error {
+	var buf bytes.Buffer
+	var offsets []int
+	object := func(body string) {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+	kids := make([]string, len(pages))
+	for i := range pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 4+2*i)
+	}
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages)))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Courier /Encoding /WinAnsiEncoding >>")
+
+	for i, lines := range pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>",
+			pdfPageWidth, pdfPageHeight, 5+2*i))
+
+		var content bytes.Buffer
+		fmt.Fprintf(&content, "BT\n/F1 %d Tf\n%d TL\n%d %d Td\n", pdfFontSize, pdfLeading, pdfMargin, pdfPageHeight-pdfMargin)
+		for _, line := range lines {
+			content.WriteString(pdfString(line))
+			content.WriteString(" Tj T*\n")
+		}
+		content.WriteString("ET")
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", content.Len(), content.String()))
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// pdfString encodes s as a literal string in WinAnsiEncoding. Characters it can not show
+// become question marks.
+func pdfString(s string) string {
+	var b strings.Builder
+	b.WriteByte('(')
+	for _, r := range s {
+		if replacement, ok := pdfTransliteration[r]; ok {
+			b.WriteString(replacement)
+			continue
+		}
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r >= 0x20 && r < 0x7f:
+			b.WriteRune(r)
+		case r >= 0xa0 && r <= 0xff:
+			// Latin-1 letters like ç, ö and ü have the same code in WinAnsiEncoding
+			b.WriteByte(byte(r))
+		default:
+			b.WriteByte('?')
+		}
+	}
+	b.WriteByte(')')
+	return b.String()
+}
@@ -0,0 +1,181 @@
+package invoice
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"tzcnlr/auth"
+)
+
+const mimePDF = "application/pdf"
+
+var currencyPattern = regexp.MustCompile(`^[A-Z]{3}$`)
+
+type InvoiceAPI struct {
+	s *InvoiceService
+}
+
+func NewInvoiceAPI(s *InvoiceService) *InvoiceAPI {
+	return &InvoiceAPI{
+		s: s,
+	}
+}
+
+// HandlePostInvoice issues the invoice for a company and month and answers with it.
+func (api *InvoiceAPI) HandlePostInvoice(w http.ResponseWriter, r *http.Request) {
+	body, ok := r.Context().Value("body").([]byte)
+	if !ok {
+		http.Error(w, "error accessing the body of the request", http.StatusInternalServerError)
+		return
+	}
+
+	if len(body) == 0 {
+		http.Error(w, "empty request body", http.StatusBadRequest)
+		return
+	}
+
+	var request InvoiceRequest
+	if err := json.Unmarshal(body, &request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if request.CompanyName == "" {
+		http.Error(w, "company name not provided in request body", http.StatusBadRequest)
+		return
+	}
+	month, err := time.Parse("2006-01", request.Month)
+	if err != nil {
+		http.Error(w, "month must look like 2024-03", http.StatusBadRequest)
+		return
+	}
+	if (request.HourlyRate != nil && *request.HourlyRate < 0) || (request.DailyRate != nil && *request.DailyRate < 0) {
+		http.Error(w, "rates can not be negative", http.StatusBadRequest)
+		return
+	}
+	if request.Currency != "" && !currencyPattern.MatchString(request.Currency) {
+		http.Error(w, "currency must be a three letter ISO 4217 code like TRY", http.StatusBadRequest)
+		return
+	}
+	if request.VATRate != nil && (*request.VATRate < 0 || *request.VATRate > 100) {
+		http.Error(w, "vatRate must be a percentage between 0 and 100", http.StatusBadRequest)
+		return
+	}
+
+	inv, err := api.s.CreateInvoice(request, month, month.AddDate(0, 1, 0), auth.Actor(r.Context()))
+	if errors.Is(err, ErrUnknownCompany) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, ErrNoBillableTasks) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if unpricedErr := (*UnpricedError)(nil); errors.As(err, &unpricedErr) || errors.Is(err, ErrCurrencyMismatch) || errors.Is(err, ErrCurrencyMissing) {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	jsonResponse, err := json.Marshal(inv)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(jsonResponse)
+}
+
+// HandleGetInvoiceByID answers with the invoice as JSON, or as PDF when the Accept header asks
+// for application/pdf or the format parameter is pdf.
+func (api *InvoiceAPI) HandleGetInvoiceByID(w http.ResponseWriter, r *http.Request) {
+	invoiceID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "invalid invoice id", http.StatusBadRequest)
+		return
+	}
+
+	inv, err := api.s.GetInvoiceByID(invoiceID)
+	if errors.Is(err, ErrInvoiceNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if _, err = auth.ScopeCompanyName(r.Context(), inv.currentCompanyName); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	if r.URL.Query().Get("format") == "pdf" || acceptsPDF(r.Header.Get("Accept")) {
+		w.Header().Set("Content-Type", mimePDF)
+		w.Header().Set("Content-Disposition", `attachment; filename="invoice-`+inv.InvoiceNumber+`.pdf"`)
+		if err = WritePDF(w, inv); err != nil {
+			log.Printf("error writing invoice pdf: %v\n", err)
+		}
+		return
+	}
+
+	jsonResponse, err := json.Marshal(inv)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
+
+func (api *InvoiceAPI) HandleGetInvoices(w http.ResponseWriter, r *http.Request) {
+	companyName, err := auth.ScopeCompanyName(r.Context(), r.URL.Query().Get("companyName"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	result, err := api.s.GetInvoices(companyName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// dont return null
+	if result == nil {
+		result = []Invoice{}
+	}
+
+	jsonResponse, err := json.Marshal(result)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
+
+func acceptsPDF(accept string) bool {
+	for _, mediaRange := range strings.Split(accept, ",") {
+		mediaType, _, _ := strings.Cut(mediaRange, ";")
+		if strings.TrimSpace(mediaType) == mimePDF {
+			return true
+		}
+	}
+	return false
+}
@@ -3,9 +3,18 @@ package machine
 import (
 	"context"
 	"errors"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"strconv"
+	"tzcnlr/audit"
 )
 
+// ErrMachineInUse is returned when deleting a machine that completed tasks still reference.
+var ErrMachineInUse = errors.New("machine has completed tasks and cannot be deleted, archive it instead")
+
+var ErrMachineNotFound = errors.New("machineName does not exist")
+
 type MachineDB struct {
 	db *pgxpool.Pool
 }
@@ -16,55 +25,129 @@ func NewMachineDB(db *pgxpool.Pool) *MachineDB {
 	}
 }
 
-func (c *MachineDB) PutMachine(machineName string) error {
-	query := "insert into machine (machine_name) values ($1)"
-	_, err := c.db.Exec(context.Background(), query, machineName)
-	return err
-}
-
-func (c *MachineDB) DeleteMachineByName(machineName string) error {
-	sql := `DELETE FROM machine WHERE machine_name=$1`
+// lockMachine reads a machine and locks it until tx ends, nil if it does not exist.
+func lockMachine(ctx context.Context, tx pgx.Tx, machineName string) (*Machine, error) {
+	query := "select machine_id, machine_name, archived_at from machine where machine_name = $1 for update"
 
-	res, err := c.db.Exec(context.Background(), sql, machineName)
-	if err != nil {
-		return err
+	var machine Machine
+	err := tx.QueryRow(ctx, query, machineName).Scan(&machine.MachineID, &machine.MachineName, &machine.ArchivedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
 	}
-	if res.RowsAffected() == 0 {
-		return errors.New("machineName does not exist")
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	return &machine, nil
 }
 
-func (c *MachineDB) UpdateMachineByName(machineName, newMachineName string) error {
-	sql := `UPDATE machine SET machine_name=$1 WHERE machine_name=$2`
+func (c *MachineDB) PutMachine(machineName string, record audit.Recorder) error {
+	ctx := context.Background()
+	return pgx.BeginFunc(ctx, c.db, func(tx pgx.Tx) error {
+		query := "insert into machine (machine_name) values ($1)"
+		if _, err := tx.Exec(ctx, query, machineName); err != nil {
+			return err
+		}
 
-	res, err := c.db.Exec(context.Background(), sql, newMachineName, machineName)
-	if err != nil {
-		return err
-	}
-	if res.RowsAffected() == 0 {
-		return errors.New("machineName does not exist")
-	}
-	return nil
+		after, err := lockMachine(ctx, tx, machineName)
+		if err != nil {
+			return err
+		}
+		return record(tx, strconv.Itoa(after.MachineID), nil, after)
+	})
+}
+
+func (c *MachineDB) DeleteMachineByName(machineName string, record audit.Recorder) error {
+	ctx := context.Background()
+	return pgx.BeginFunc(ctx, c.db, func(tx pgx.Tx) error {
+		before, err := lockMachine(ctx, tx, machineName)
+		if err != nil {
+			return err
+		}
+		if before == nil {
+			return ErrMachineNotFound
+		}
+
+		_, err = tx.Exec(ctx, `DELETE FROM machine WHERE machine_id=$1`, before.MachineID)
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+			return ErrMachineInUse
+		}
+		if err != nil {
+			return err
+		}
+		return record(tx, strconv.Itoa(before.MachineID), before, nil)
+	})
 }
 
-func (c *MachineDB) GetMachines() ([]Machine, error) {
+func (c *MachineDB) UpdateMachineByName(machineName, newMachineName string, record audit.Recorder) error {
+	ctx := context.Background()
+	return pgx.BeginFunc(ctx, c.db, func(tx pgx.Tx) error {
+		before, err := lockMachine(ctx, tx, machineName)
+		if err != nil {
+			return err
+		}
+		if before == nil {
+			return ErrMachineNotFound
+		}
+
+		if _, err = tx.Exec(ctx, `UPDATE machine SET machine_name=$1 WHERE machine_id=$2`, newMachineName, before.MachineID); err != nil {
+			return err
+		}
+
+		after, err := lockMachine(ctx, tx, newMachineName)
+		if err != nil {
+			return err
+		}
+		return record(tx, strconv.Itoa(before.MachineID), before, after)
+	})
+}
+
+// SetMachineArchived archives or restores a machine, archiving keeps the original archived_at.
+func (c *MachineDB) SetMachineArchived(machineName string, archived bool, record audit.Recorder) error {
+	ctx := context.Background()
+	return pgx.BeginFunc(ctx, c.db, func(tx pgx.Tx) error {
+		before, err := lockMachine(ctx, tx, machineName)
+		if err != nil {
+			return err
+		}
+		if before == nil {
+			return ErrMachineNotFound
+		}
+
+		sql := `UPDATE machine SET archived_at = CASE WHEN $2 THEN COALESCE(archived_at, now()) END WHERE machine_id=$1`
+		if _, err = tx.Exec(ctx, sql, before.MachineID, archived); err != nil {
+			return err
+		}
 
-	query := "select machine_id, machine_name from machine"
-	var companies []Machine
-	rows, err := c.db.Query(context.Background(), query)
+		after, err := lockMachine(ctx, tx, machineName)
+		if err != nil {
+			return err
+		}
+		return record(tx, strconv.Itoa(before.MachineID), before, after)
+	})
+}
+
+func (c *MachineDB) GetMachines(includeArchived bool) ([]Machine, error) {
+
+	query := "select machine_id, machine_name, archived_at from machine where $1 or archived_at is null"
+	var machines []Machine
+	rows, err := c.db.Query(context.Background(), query, includeArchived)
 	if err != nil {
 		return nil, err
 	}
 
+	defer rows.Close()
 	for rows.Next() {
 		var machine Machine
-		err := rows.Scan(&machine.MachineID, &machine.MachineName)
+		err := rows.Scan(&machine.MachineID, &machine.MachineName, &machine.ArchivedAt)
 
 		if err != nil {
 			return nil, err
 		}
-		companies = append(companies, machine)
+		machines = append(machines, machine)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
 	}
-	return companies, nil
+	return machines, nil
 }
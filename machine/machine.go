@@ -1,8 +1,15 @@
 package machine
 
+import (
+	"time"
+	"tzcnlr/audit"
+)
+
 type Machine struct {
 	MachineID   int    `json:"id"`
 	MachineName string `json:"machineName"`
+	// ArchivedAt is set for machines taken out of service, they only remain for the history.
+	ArchivedAt *time.Time `json:"archivedAt,omitempty"`
 }
 
 type MachineService struct {
@@ -15,22 +22,48 @@ func NewMachineService(cDB *MachineDB) *MachineService {
 	}
 }
 
-func (s *MachineService) PutMachine(machine Machine) error {
-	err := s.cDB.PutMachine(machine.MachineName)
+// PutMachine stores a new machine, record writes its audit entry in the same transaction. The
+// other changes take a Recorder the same way.
+func (s *MachineService) PutMachine(machine Machine, record audit.Recorder) error {
+	err := s.cDB.PutMachine(machine.MachineName, record)
 	return err
 }
 
-func (s *MachineService) DeleteMachineByName(machineName string) error {
-	err := s.cDB.DeleteMachineByName(machineName)
+func (s *MachineService) DeleteMachineByName(machineName string, record audit.Recorder) error {
+	err := s.cDB.DeleteMachineByName(machineName, record)
 	return err
 }
 
-func (s *MachineService) UpdateMachineByName(machineName string, newMachine Machine) error {
-	err := s.cDB.UpdateMachineByName(machineName, newMachine.MachineName)
+func (s *MachineService) UpdateMachineByName(machineName string, newMachine Machine, record audit.Recorder) error {
+	err := s.cDB.UpdateMachineByName(machineName, newMachine.MachineName, record)
 	return err
 }
 
-func (s *MachineService) GetMachines() ([]Machine, error) {
-	result, err := s.cDB.GetMachines()
+// ArchiveMachineByName hides the machine from lists and new tasks, its past tasks keep it.
+func (s *MachineService) ArchiveMachineByName(machineName string, record audit.Recorder) error {
+	return s.cDB.SetMachineArchived(machineName, true, record)
+}
+
+func (s *MachineService) RestoreMachineByName(machineName string, record audit.Recorder) error {
+	return s.cDB.SetMachineArchived(machineName, false, record)
+}
+
+// GetMachineByName returns a machine whether it is archived or not.
+func (s *MachineService) GetMachineByName(machineName string) (Machine, error) {
+	machines, err := s.cDB.GetMachines(true)
+	if err != nil {
+		return Machine{}, err
+	}
+	for _, machine := range machines {
+		if machine.MachineName == machineName {
+			return machine, nil
+		}
+	}
+	return Machine{}, ErrMachineNotFound
+}
+
+// GetMachines returns the active machines, and the archived ones too if includeArchived is set.
+func (s *MachineService) GetMachines(includeArchived bool) ([]Machine, error) {
+	result, err := s.cDB.GetMachines(includeArchived)
 	return result, err
 }
@@ -3,17 +3,22 @@ package machine
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"github.com/gorilla/mux"
 	"net/http"
+	"strconv"
+	"tzcnlr/audit"
 )
 
 type MachineAPI struct {
-	s *MachineService
+	s     *MachineService
+	audit *audit.AuditService
 }
 
-func NewMachineAPI(s *MachineService) *MachineAPI {
+func NewMachineAPI(s *MachineService, auditService *audit.AuditService) *MachineAPI {
 	return &MachineAPI{
-		s: s,
+		s:     s,
+		audit: auditService,
 	}
 }
 
@@ -31,7 +36,7 @@ func (api *MachineAPI) HandleUpdateMachineByName(w http.ResponseWriter, r *http.
 		return
 	}
 
-	err := api.s.UpdateMachineByName(currentName, machine)
+	err := api.s.UpdateMachineByName(currentName, machine, api.audit.Recorder(r.Context(), audit.ActionUpdate, audit.EntityMachine))
 	if err != nil {
 		http.Error(w, "Error updating machine: "+err.Error(), http.StatusInternalServerError)
 		return
@@ -47,7 +52,11 @@ func (api *MachineAPI) HandleDeleteMachineByName(w http.ResponseWriter, r *http.
 		return
 	}
 
-	err := api.s.DeleteMachineByName(machineName)
+	err := api.s.DeleteMachineByName(machineName, api.audit.Recorder(r.Context(), audit.ActionDelete, audit.EntityMachine))
+	if errors.Is(err, ErrMachineInUse) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -62,7 +71,38 @@ func (api *MachineAPI) HandlePostMachine(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	err := api.s.PutMachine(machine)
+	err := api.s.PutMachine(machine, api.audit.Recorder(r.Context(), audit.ActionCreate, audit.EntityMachine))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (api *MachineAPI) HandleArchiveMachineByName(w http.ResponseWriter, r *http.Request) {
+	api.setMachineArchived(w, r, true)
+}
+
+func (api *MachineAPI) HandleRestoreMachineByName(w http.ResponseWriter, r *http.Request) {
+	api.setMachineArchived(w, r, false)
+}
+
+func (api *MachineAPI) setMachineArchived(w http.ResponseWriter, r *http.Request, archived bool) {
+	machineName := mux.Vars(r)["machineName"]
+	if machineName == "" {
+		http.Error(w, "machineName not set", http.StatusBadRequest)
+		return
+	}
+
+	var err error
+	if archived {
+		err = api.s.ArchiveMachineByName(machineName, api.audit.Recorder(r.Context(), audit.ActionArchive, audit.EntityMachine))
+	} else {
+		err = api.s.RestoreMachineByName(machineName, api.audit.Recorder(r.Context(), audit.ActionRestore, audit.EntityMachine))
+	}
+	if errors.Is(err, ErrMachineNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -70,7 +110,16 @@ func (api *MachineAPI) HandlePostMachine(w http.ResponseWriter, r *http.Request)
 }
 
 func (api *MachineAPI) HandleGetMachines(w http.ResponseWriter, r *http.Request) {
-	result, err := api.s.GetMachines()
+	includeArchived := false
+	if value := r.URL.Query().Get("includeArchived"); value != "" {
+		var err error
+		if includeArchived, err = strconv.ParseBool(value); err != nil {
+			http.Error(w, "invalid includeArchived: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	result, err := api.s.GetMachines(includeArchived)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
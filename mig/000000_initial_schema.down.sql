@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS completed_task_logs;
+DROP TABLE IF EXISTS branch;
+DROP TABLE IF EXISTS machine;
+DROP TABLE IF EXISTS company;
@@ -0,0 +1,3 @@
+-- existing users predate roles and were all administrators
+ALTER TABLE users ADD COLUMN IF NOT EXISTS role VARCHAR(32) NOT NULL DEFAULT 'admin' CHECK (role IN ('admin', 'dispatcher', 'viewer'));
+ALTER TABLE users ALTER COLUMN role SET DEFAULT 'viewer';
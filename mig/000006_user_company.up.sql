@@ -0,0 +1,2 @@
+-- users bound to a company only see that company's data, admins are never bound
+ALTER TABLE users ADD COLUMN IF NOT EXISTS company_id INT REFERENCES company(company_id) ON DELETE CASCADE CHECK (company_id IS NULL OR role != 'admin');
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS completed_task_logs_machine_booking_idx;
+DROP EXTENSION IF EXISTS btree_gist;
@@ -0,0 +1,3 @@
+-- backs the overlap check on machine bookings, the booked time of a task is [start, end)
+CREATE EXTENSION IF NOT EXISTS btree_gist;
+CREATE INDEX IF NOT EXISTS completed_task_logs_machine_booking_idx ON completed_task_logs USING gist (machine_name, tsrange(task_start_date + task_start_time, task_end_date + task_end_time));
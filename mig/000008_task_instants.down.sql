@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS completed_task_logs_machine_range_idx;
+ALTER TABLE completed_task_logs DROP COLUMN IF EXISTS task_end;
+ALTER TABLE completed_task_logs DROP COLUMN IF EXISTS task_start;
+CREATE INDEX IF NOT EXISTS completed_task_logs_machine_booking_idx ON completed_task_logs USING gist (machine_name, tsrange(task_start_date + task_start_time, task_end_date + task_end_time));
@@ -0,0 +1,13 @@
+-- start and end of a task as instants, derived from the Istanbul wall clock DATE and TIME columns
+ALTER TABLE completed_task_logs ADD COLUMN IF NOT EXISTS task_start TIMESTAMPTZ;
+ALTER TABLE completed_task_logs ADD COLUMN IF NOT EXISTS task_end TIMESTAMPTZ;
+UPDATE completed_task_logs SET
+    task_start = (task_start_date + task_start_time) AT TIME ZONE 'Europe/Istanbul',
+    task_end = (task_end_date + task_end_time) AT TIME ZONE 'Europe/Istanbul'
+WHERE task_start IS NULL OR task_end IS NULL;
+ALTER TABLE completed_task_logs ALTER COLUMN task_start SET NOT NULL;
+ALTER TABLE completed_task_logs ALTER COLUMN task_end SET NOT NULL;
+
+-- the overlap check on machine bookings runs on the instants, a task books [start, end)
+DROP INDEX IF EXISTS completed_task_logs_machine_booking_idx;
+CREATE INDEX IF NOT EXISTS completed_task_logs_machine_range_idx ON completed_task_logs USING gist (machine_name, tstzrange(task_start, task_end));
@@ -0,0 +1,3 @@
+ALTER TABLE completed_task_logs DROP COLUMN IF EXISTS time_zone;
+ALTER TABLE branch DROP COLUMN IF EXISTS time_zone;
+ALTER TABLE company DROP COLUMN IF EXISTS time_zone;
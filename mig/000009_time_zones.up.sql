@@ -0,0 +1,6 @@
+-- zones of companies and branches, NULL falls back to the company and then to TIME_ZONE
+ALTER TABLE company ADD COLUMN IF NOT EXISTS time_zone VARCHAR(64);
+ALTER TABLE branch ADD COLUMN IF NOT EXISTS time_zone VARCHAR(64);
+
+-- the zone the DATE and TIME columns of a task are wall clock values in, every task so far was recorded in Istanbul
+ALTER TABLE completed_task_logs ADD COLUMN IF NOT EXISTS time_zone VARCHAR(64) NOT NULL DEFAULT 'Europe/Istanbul';
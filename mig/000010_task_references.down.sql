@@ -0,0 +1,26 @@
+ALTER TABLE completed_task_logs ADD COLUMN company_name VARCHAR(255);
+ALTER TABLE completed_task_logs ADD COLUMN branch_name VARCHAR(255);
+ALTER TABLE completed_task_logs ADD COLUMN machine_name VARCHAR(255);
+
+UPDATE completed_task_logs t SET
+    company_name = c.company_name,
+    branch_name = b.branch_name,
+    machine_name = m.machine_name
+FROM company c, branch b, machine m
+WHERE c.company_id = t.company_id AND b.branch_id = t.branch_id AND m.machine_id = t.machine_id;
+
+ALTER TABLE completed_task_logs ALTER COLUMN company_name SET NOT NULL;
+ALTER TABLE completed_task_logs ALTER COLUMN branch_name SET NOT NULL;
+ALTER TABLE completed_task_logs ALTER COLUMN machine_name SET NOT NULL;
+
+-- dropping the id columns also drops their foreign keys, the unique constraint and the indexes
+ALTER TABLE completed_task_logs DROP COLUMN company_id;
+ALTER TABLE completed_task_logs DROP COLUMN branch_id;
+ALTER TABLE completed_task_logs DROP COLUMN machine_id;
+ALTER TABLE branch DROP CONSTRAINT branch_id_company_id_key;
+
+ALTER TABLE completed_task_logs ADD CONSTRAINT completed_task_logs_company_name_machine_name_branch_name_t_key
+    UNIQUE (company_name, machine_name, branch_name, task_start_date, task_start_time, task_duration_in_minutes, is_rental);
+CREATE INDEX completed_task_logs_machine_range_idx ON completed_task_logs USING gist (machine_name, tstzrange(task_start, task_end));
+
+DROP TABLE IF EXISTS recreated_entities;
@@ -0,0 +1,68 @@
+-- completed tasks reference their company, branch and machine by id, so renames carry over
+-- to the history and entities with logged tasks cannot be deleted underneath it
+ALTER TABLE completed_task_logs ADD COLUMN company_id INT;
+ALTER TABLE completed_task_logs ADD COLUMN branch_id INT;
+ALTER TABLE completed_task_logs ADD COLUMN machine_id INT;
+
+-- names left dangling by earlier renames and deletes are recreated, so no history is lost. They
+-- are noted in recreated_entities until archiving exists and they can be archived, see 000017.
+CREATE TABLE recreated_entities (
+    entity_type VARCHAR(32) NOT NULL,
+    entity_id INT NOT NULL
+);
+
+WITH recreated AS (
+    INSERT INTO company (company_name)
+    SELECT DISTINCT company_name FROM completed_task_logs
+    ON CONFLICT (company_name) DO NOTHING
+    RETURNING company_id
+)
+INSERT INTO recreated_entities (entity_type, entity_id) SELECT 'company', company_id FROM recreated;
+
+WITH recreated AS (
+    INSERT INTO branch (branch_name, company_id)
+    SELECT DISTINCT t.branch_name, c.company_id
+    FROM completed_task_logs t JOIN company c ON c.company_name = t.company_name
+    ON CONFLICT (branch_name, company_id) DO NOTHING
+    RETURNING branch_id
+)
+INSERT INTO recreated_entities (entity_type, entity_id) SELECT 'branch', branch_id FROM recreated;
+
+WITH recreated AS (
+    INSERT INTO machine (machine_name)
+    SELECT DISTINCT machine_name FROM completed_task_logs
+    ON CONFLICT (machine_name) DO NOTHING
+    RETURNING machine_id
+)
+INSERT INTO recreated_entities (entity_type, entity_id) SELECT 'machine', machine_id FROM recreated;
+
+UPDATE completed_task_logs t SET
+    company_id = c.company_id,
+    branch_id = b.branch_id,
+    machine_id = m.machine_id
+FROM company c, branch b, machine m
+WHERE c.company_name = t.company_name
+    AND b.company_id = c.company_id AND b.branch_name = t.branch_name
+    AND m.machine_name = t.machine_name;
+
+ALTER TABLE completed_task_logs ALTER COLUMN company_id SET NOT NULL;
+ALTER TABLE completed_task_logs ALTER COLUMN branch_id SET NOT NULL;
+ALTER TABLE completed_task_logs ALTER COLUMN machine_id SET NOT NULL;
+
+-- the branch of a task always belongs to the company of the task
+ALTER TABLE branch ADD CONSTRAINT branch_id_company_id_key UNIQUE (branch_id, company_id);
+
+ALTER TABLE completed_task_logs
+    ADD CONSTRAINT completed_task_logs_company_id_fkey FOREIGN KEY (company_id) REFERENCES company(company_id) ON UPDATE CASCADE ON DELETE RESTRICT,
+    ADD CONSTRAINT completed_task_logs_branch_id_fkey FOREIGN KEY (branch_id, company_id) REFERENCES branch(branch_id, company_id) ON UPDATE CASCADE ON DELETE RESTRICT,
+    ADD CONSTRAINT completed_task_logs_machine_id_fkey FOREIGN KEY (machine_id) REFERENCES machine(machine_id) ON UPDATE CASCADE ON DELETE RESTRICT;
+
+-- dropping the name columns also drops the old unique constraint and the machine booking index
+ALTER TABLE completed_task_logs DROP COLUMN company_name;
+ALTER TABLE completed_task_logs DROP COLUMN branch_name;
+ALTER TABLE completed_task_logs DROP COLUMN machine_name;
+
+ALTER TABLE completed_task_logs ADD CONSTRAINT completed_task_logs_entry_key
+    UNIQUE (company_id, machine_id, branch_id, task_start_date, task_start_time, task_duration_in_minutes, is_rental);
+CREATE INDEX completed_task_logs_machine_range_idx ON completed_task_logs USING gist (machine_id, tstzrange(task_start, task_end));
+CREATE INDEX completed_task_logs_branch_id_idx ON completed_task_logs (branch_id);
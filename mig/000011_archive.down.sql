@@ -0,0 +1,3 @@
+ALTER TABLE machine DROP COLUMN archived_at;
+ALTER TABLE branch DROP COLUMN archived_at;
+ALTER TABLE company DROP COLUMN archived_at;
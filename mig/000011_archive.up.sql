@@ -0,0 +1,4 @@
+-- archived entities are hidden from lists and new tasks but stay referenced by their past tasks
+ALTER TABLE company ADD COLUMN archived_at TIMESTAMPTZ;
+ALTER TABLE branch ADD COLUMN archived_at TIMESTAMPTZ;
+ALTER TABLE machine ADD COLUMN archived_at TIMESTAMPTZ;
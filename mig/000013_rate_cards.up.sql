@@ -0,0 +1,18 @@
+-- rates are in minor currency units. A card without company_id is the default price of the
+-- machine, a card with one overrides it for that company. valid_until is exclusive, NULL while
+-- the card is current.
+CREATE TABLE rate_card (
+    rate_card_id SERIAL PRIMARY KEY,
+    machine_id INT NOT NULL REFERENCES machine (machine_id) ON DELETE CASCADE,
+    company_id INT REFERENCES company (company_id) ON DELETE CASCADE,
+    hourly_rate BIGINT NOT NULL CHECK (hourly_rate >= 0),
+    daily_rate BIGINT NOT NULL CHECK (daily_rate >= 0),
+    currency CHAR(3) NOT NULL,
+    valid_from DATE NOT NULL,
+    valid_until DATE CHECK (valid_until > valid_from),
+    CONSTRAINT rate_card_no_overlap EXCLUDE USING gist (
+        machine_id WITH =,
+        (COALESCE(company_id, 0)) WITH =,
+        daterange(valid_from, valid_until) WITH &&
+    )
+);
@@ -0,0 +1,6 @@
+DROP TRIGGER completed_task_invoiced ON completed_task_logs;
+DROP FUNCTION completed_task_invoiced_check();
+DROP TABLE invoice_task;
+DROP TABLE invoice_line;
+DROP TABLE invoice;
+DROP TABLE invoice_number_counter;
@@ -0,0 +1,64 @@
+-- invoice numbers run without gaps per year, the counter row is locked while an invoice is issued
+CREATE TABLE invoice_number_counter (
+    year INT PRIMARY KEY,
+    last_number INT NOT NULL
+);
+
+-- names are copied onto the invoice, an issued invoice must not change with later renames
+CREATE TABLE invoice (
+    invoice_id SERIAL PRIMARY KEY,
+    invoice_number VARCHAR(32) NOT NULL UNIQUE,
+    company_id INT NOT NULL REFERENCES company (company_id) ON UPDATE CASCADE ON DELETE RESTRICT,
+    company_name VARCHAR(255) NOT NULL,
+    period_start DATE NOT NULL,
+    period_end DATE NOT NULL CHECK (period_end > period_start),
+    currency CHAR(3) NOT NULL,
+    vat_rate INT NOT NULL CHECK (vat_rate >= 0),
+    net_amount BIGINT NOT NULL,
+    vat_amount BIGINT NOT NULL,
+    total_amount BIGINT NOT NULL,
+    issued_by VARCHAR(255) NOT NULL,
+    issued_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX invoice_company_id_idx ON invoice (company_id, period_start);
+
+CREATE TABLE invoice_line (
+    invoice_id INT NOT NULL REFERENCES invoice (invoice_id) ON DELETE CASCADE,
+    line_no INT NOT NULL,
+    branch_name VARCHAR(255) NOT NULL,
+    machine_name VARCHAR(255) NOT NULL,
+    is_rental BOOLEAN NOT NULL,
+    minutes INT NOT NULL,
+    unit_rate BIGINT NOT NULL,
+    amount BIGINT NOT NULL,
+    PRIMARY KEY (invoice_id, line_no)
+);
+
+-- a task is billed at most once
+CREATE TABLE invoice_task (
+    task_id INT PRIMARY KEY REFERENCES completed_task_logs (task_id) ON DELETE RESTRICT,
+    invoice_id INT NOT NULL,
+    line_no INT NOT NULL,
+    FOREIGN KEY (invoice_id, line_no) REFERENCES invoice_line (invoice_id, line_no) ON DELETE CASCADE
+);
+
+CREATE INDEX invoice_task_invoice_id_idx ON invoice_task (invoice_id, line_no);
+
+-- invoiced tasks are locked, whatever path tries to change or delete them
+CREATE FUNCTION completed_task_invoiced_check() RETURNS trigger AS $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM invoice_task WHERE task_id = OLD.task_id) THEN
+        RAISE EXCEPTION 'completed task % is invoiced', OLD.task_id
+            USING ERRCODE = 'restrict_violation', CONSTRAINT = 'completed_task_invoiced';
+    END IF;
+    IF TG_OP = 'DELETE' THEN
+        RETURN OLD;
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER completed_task_invoiced
+    BEFORE UPDATE OR DELETE ON completed_task_logs
+    FOR EACH ROW EXECUTE FUNCTION completed_task_invoiced_check();
@@ -0,0 +1,8 @@
+-- '!' is no bcrypt hash, users that only had a provider can not log in until given a password
+UPDATE users SET password_hash = '!' WHERE password_hash IS NULL;
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_credentials_check;
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_external_identity_check;
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_external_identity_key;
+ALTER TABLE users ALTER COLUMN password_hash SET NOT NULL;
+ALTER TABLE users DROP COLUMN IF EXISTS external_subject;
+ALTER TABLE users DROP COLUMN IF EXISTS external_issuer;
@@ -0,0 +1,9 @@
+-- users of an identity provider are linked by its issuer and their stable subject there, never
+-- by username. Linked users sign in through the provider only and have no local password.
+ALTER TABLE users ADD COLUMN external_issuer VARCHAR(255);
+ALTER TABLE users ADD COLUMN external_subject VARCHAR(255);
+ALTER TABLE users ALTER COLUMN password_hash DROP NOT NULL;
+
+ALTER TABLE users ADD CONSTRAINT users_external_identity_key UNIQUE (external_issuer, external_subject);
+ALTER TABLE users ADD CONSTRAINT users_external_identity_check CHECK ((external_issuer IS NULL) = (external_subject IS NULL));
+ALTER TABLE users ADD CONSTRAINT users_credentials_check CHECK ((password_hash IS NULL) != (external_subject IS NULL));
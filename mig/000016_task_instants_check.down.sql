@@ -0,0 +1 @@
+ALTER TABLE completed_task_logs DROP CONSTRAINT IF EXISTS completed_task_logs_instants_check;
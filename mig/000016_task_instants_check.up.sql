@@ -0,0 +1,14 @@
+-- the instants of a task are what the overlap check and the filters run on, the DATE and TIME
+-- columns remain for older clients. Rows that drifted apart take their wall clock from the instants.
+UPDATE completed_task_logs SET
+    task_start_date = (task_start AT TIME ZONE time_zone)::date,
+    task_start_time = (task_start AT TIME ZONE time_zone)::time,
+    task_end_date = (task_end AT TIME ZONE time_zone)::date,
+    task_end_time = (task_end AT TIME ZONE time_zone)::time
+WHERE task_start IS DISTINCT FROM (task_start_date + task_start_time) AT TIME ZONE time_zone
+   OR task_end IS DISTINCT FROM (task_end_date + task_end_time) AT TIME ZONE time_zone;
+
+ALTER TABLE completed_task_logs ADD CONSTRAINT completed_task_logs_instants_check CHECK (
+    task_start = (task_start_date + task_start_time) AT TIME ZONE time_zone
+    AND task_end = (task_end_date + task_end_time) AT TIME ZONE time_zone
+);
@@ -0,0 +1,14 @@
+-- restores what 000017 archived, as its audit entries tell, and notes it for 000010 again
+CREATE TABLE recreated_entities (
+    entity_type VARCHAR(32) NOT NULL,
+    entity_id INT NOT NULL
+);
+
+INSERT INTO recreated_entities (entity_type, entity_id)
+SELECT entity_type, entity_id::int FROM audit_log WHERE actor = 'migration' AND action = 'archive';
+
+UPDATE company SET archived_at = NULL WHERE company_id IN (SELECT entity_id FROM recreated_entities WHERE entity_type = 'company');
+UPDATE branch SET archived_at = NULL WHERE branch_id IN (SELECT entity_id FROM recreated_entities WHERE entity_type = 'branch');
+UPDATE machine SET archived_at = NULL WHERE machine_id IN (SELECT entity_id FROM recreated_entities WHERE entity_type = 'machine');
+
+DELETE FROM audit_log WHERE actor = 'migration' AND action = 'archive';
@@ -0,0 +1,44 @@
+-- companies, branches and machines 000010 recreated for the tasks of deleted ones are archived,
+-- they only exist for those tasks. The audit log reports each of them. Databases migrated
+-- before 000010 noted them have no recreated_entities and nothing to archive.
+CREATE TABLE IF NOT EXISTS recreated_entities (
+    entity_type VARCHAR(32) NOT NULL,
+    entity_id INT NOT NULL
+);
+
+WITH archived AS (
+    UPDATE company SET archived_at = COALESCE(archived_at, now())
+    WHERE company_id IN (SELECT entity_id FROM recreated_entities WHERE entity_type = 'company')
+    RETURNING company_id, company_name, archived_at
+)
+INSERT INTO audit_log (actor, action, entity_type, entity_id, before_state, after_state)
+SELECT 'migration', 'archive', 'company', company_id::text,
+    jsonb_build_object('id', company_id, 'companyName', company_name),
+    jsonb_build_object('id', company_id, 'companyName', company_name, 'archivedAt', archived_at)
+FROM archived;
+
+WITH archived AS (
+    UPDATE branch b SET archived_at = COALESCE(b.archived_at, now())
+    FROM company c
+    WHERE c.company_id = b.company_id
+        AND b.branch_id IN (SELECT entity_id FROM recreated_entities WHERE entity_type = 'branch')
+    RETURNING b.branch_id, b.branch_name, c.company_name, b.archived_at
+)
+INSERT INTO audit_log (actor, action, entity_type, entity_id, before_state, after_state)
+SELECT 'migration', 'archive', 'branch', branch_id::text,
+    jsonb_build_object('id', branch_id, 'branchName', branch_name, 'companyName', company_name),
+    jsonb_build_object('id', branch_id, 'branchName', branch_name, 'companyName', company_name, 'archivedAt', archived_at)
+FROM archived;
+
+WITH archived AS (
+    UPDATE machine SET archived_at = COALESCE(archived_at, now())
+    WHERE machine_id IN (SELECT entity_id FROM recreated_entities WHERE entity_type = 'machine')
+    RETURNING machine_id, machine_name, archived_at
+)
+INSERT INTO audit_log (actor, action, entity_type, entity_id, before_state, after_state)
+SELECT 'migration', 'archive', 'machine', machine_id::text,
+    jsonb_build_object('id', machine_id, 'machineName', machine_name),
+    jsonb_build_object('id', machine_id, 'machineName', machine_name, 'archivedAt', archived_at)
+FROM archived;
+
+DROP TABLE recreated_entities;
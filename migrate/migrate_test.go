@@ -0,0 +1,88 @@
+package migrate
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   []string
+		want    []Migration
+		wantErr string
+	}{
+		{
+			name:  "ordered by version",
+			files: []string{"000010_invoices.up.sql", "000002_roles.up.sql", "000002_roles.down.sql", "000000_initial.up.sql", "000000_initial.down.sql"},
+			want: []Migration{
+				{Version: 0, Name: "initial", Up: "000000_initial.up.sql", Down: "000000_initial.down.sql"},
+				{Version: 2, Name: "roles", Up: "000002_roles.up.sql", Down: "000002_roles.down.sql"},
+				{Version: 10, Name: "invoices", Up: "000010_invoices.up.sql"},
+			},
+		},
+		{
+			name:  "versions compare as numbers",
+			files: []string{"10_later.up.sql", "9_earlier.up.sql"},
+			want: []Migration{
+				{Version: 9, Name: "earlier", Up: "9_earlier.up.sql"},
+				{Version: 10, Name: "later", Up: "10_later.up.sql"},
+			},
+		},
+		{name: "no files", want: []Migration{}},
+		{name: "down without up", files: []string{"000001_users.down.sql"}, wantErr: "has no up script"},
+		{name: "version used twice", files: []string{"000001_users.up.sql", "000001_roles.up.sql"}, wantErr: "is already used by"},
+		{name: "no direction", files: []string{"000001_users.sql"}, wantErr: "migration files are named"},
+		{name: "no version", files: []string{"users.up.sql"}, wantErr: "migration files are named"},
+		{name: "no name", files: []string{"000001.up.sql"}, wantErr: "migration files are named"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			// every script holds its own file name, so the test can tell which one ended up where
+			for _, file := range tt.files {
+				if err := os.WriteFile(filepath.Join(dir, file), []byte(file), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := os.WriteFile(filepath.Join(dir, "README.md"), []byte("not a migration"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			migrations, err := loadMigrations(dir)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error is %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(migrations, tt.want) {
+				t.Errorf("migrations are\n%+v\nwant\n%+v", migrations, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadShippedMigrations(t *testing.T) {
+	migrations, err := loadMigrations(filepath.Join("..", "mig"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) == 0 {
+		t.Fatal("no migrations found")
+	}
+	for i, m := range migrations {
+		if m.Version != int64(i) {
+			t.Errorf("migration %d_%s is number %d", m.Version, m.Name, i)
+		}
+		if m.Down == "" {
+			t.Errorf("migration %d_%s has no down script", m.Version, m.Name)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package ratecard
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var ErrRateCardNotFound = errors.New("rate card does not exist")
+
+// ErrRateCardOverlap is returned when the validity of a card overlaps another card of the
+// same machine and company.
+var ErrRateCardOverlap = errors.New("another rate card of the machine and company is valid in the same period")
+
+// ErrUnknownReference is returned when the machine or company of a card does not exist.
+var ErrUnknownReference = errors.New("machine or company does not exist")
+
+type RateCardDB struct {
+	db *pgxpool.Pool
+}
+
+func NewRateCardDB(db *pgxpool.Pool) *RateCardDB {
+	return &RateCardDB{
+		db: db,
+	}
+}
+
+const rateCardColumns = "r.rate_card_id, m.machine_name, COALESCE(c.company_name, ''), r.hourly_rate, r.daily_rate, r.currency, r.valid_from, r.valid_until"
+
+const rateCardTables = `rate_card r
+	JOIN machine m ON m.machine_id = r.machine_id
+	LEFT JOIN company c ON c.company_id = r.company_id`
+
+// translateOverlapError maps a violation of rate_card_no_overlap to ErrRateCardOverlap.
+func translateOverlapError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23P01" {
+		return ErrRateCardOverlap
+	}
+	return err
+}
+
+func (r *RateCardDB) PutRateCard(rateCard RateCard) error {
+	// an unknown company must not silently turn the card into a default, hence the join
+	query := `
+	INSERT INTO rate_card (machine_id, company_id, hourly_rate, daily_rate, currency, valid_from, valid_until)
+	SELECT m.machine_id, c.company_id, $3, $4, $5, $6, $7
+	FROM machine m
+	LEFT JOIN company c ON c.company_name = $2
+	WHERE m.machine_name = $1 AND ($2 = '' OR c.company_id IS NOT NULL)`
+
+	res, err := r.db.Exec(context.Background(), query,
+		rateCard.MachineName,
+		rateCard.CompanyName,
+		rateCard.HourlyRate,
+		rateCard.DailyRate,
+		rateCard.Currency,
+		rateCard.ValidFrom,
+		rateCard.ValidUntil,
+	)
+	if err != nil {
+		return translateOverlapError(err)
+	}
+	if res.RowsAffected() == 0 {
+		return ErrUnknownReference
+	}
+	return nil
+}
+
+func (r *RateCardDB) UpdateRateCard(rateCard RateCard) error {
+	query := `
+	UPDATE rate_card r
+	SET machine_id = m.machine_id, company_id = c.company_id, hourly_rate = $4, daily_rate = $5,
+		currency = $6, valid_from = $7, valid_until = $8
+	FROM machine m
+	LEFT JOIN company c ON c.company_name = $3
+	WHERE r.rate_card_id = $1 AND m.machine_name = $2 AND ($3 = '' OR c.company_id IS NOT NULL)`
+
+	res, err := r.db.Exec(context.Background(), query,
+		rateCard.RateCardID,
+		rateCard.MachineName,
+		rateCard.CompanyName,
+		rateCard.HourlyRate,
+		rateCard.DailyRate,
+		rateCard.Currency,
+		rateCard.ValidFrom,
+		rateCard.ValidUntil,
+	)
+	if err != nil {
+		return translateOverlapError(err)
+	}
+	if res.RowsAffected() == 0 {
+		// either the card is gone or its new machine or company did not resolve
+		if _, err = r.GetRateCardByID(rateCard.RateCardID); err != nil {
+			return err
+		}
+		return ErrUnknownReference
+	}
+	return nil
+}
+
+func (r *RateCardDB) DeleteRateCardByID(rateCardID int) error {
+	res, err := r.db.Exec(context.Background(), "DELETE FROM rate_card WHERE rate_card_id = $1", rateCardID)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return ErrRateCardNotFound
+	}
+	return nil
+}
+
+func scanRateCard(row pgx.Row) (RateCard, error) {
+	var rateCard RateCard
+	err := row.Scan(
+		&rateCard.RateCardID,
+		&rateCard.MachineName,
+		&rateCard.CompanyName,
+		&rateCard.HourlyRate,
+		&rateCard.DailyRate,
+		&rateCard.Currency,
+		&rateCard.ValidFrom,
+		&rateCard.ValidUntil)
+	return rateCard, err
+}
+
+func (r *RateCardDB) GetRateCardByID(rateCardID int) (RateCard, error) {
+	query := "SELECT " + rateCardColumns + " FROM " + rateCardTables + " WHERE r.rate_card_id = $1"
+
+	rateCard, err := scanRateCard(r.db.QueryRow(context.Background(), query, rateCardID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return rateCard, ErrRateCardNotFound
+	}
+	return rateCard, err
+}
+
+func (r *RateCardDB) GetRateCards(machineName, companyName string) ([]RateCard, error) {
+	query := "SELECT " + rateCardColumns + " FROM " + rateCardTables + `
+	WHERE ($1 = '' OR m.machine_name = $1) AND ($2 = '' OR c.company_name = $2 OR r.company_id IS NULL)
+	ORDER BY m.machine_name, c.company_name NULLS FIRST, r.valid_from`
+
+	rows, err := r.db.Query(context.Background(), query, machineName, companyName)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+	var rateCards []RateCard
+	for rows.Next() {
+		rateCard, err := scanRateCard(rows)
+		if err != nil {
+			return nil, err
+		}
+		rateCards = append(rateCards, rateCard)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return rateCards, nil
+}
@@ -0,0 +1,53 @@
+package ratecard
+
+import "time"
+
+// RateCard prices the tasks of a machine started between ValidFrom and ValidUntil. Service
+// tasks are charged HourlyRate pro rata, rentals DailyRate per day, both in minor units of
+// Currency. Without CompanyName the card applies to every company that has no card of its own.
+type RateCard struct {
+	RateCardID  int       `json:"id"`
+	MachineName string    `json:"machineName"`
+	CompanyName string    `json:"companyName,omitempty"`
+	HourlyRate  int64     `json:"hourlyRate"`
+	DailyRate   int64     `json:"dailyRate"`
+	Currency    string    `json:"currency"`
+	ValidFrom   time.Time `json:"validFrom"`
+	// ValidUntil is the first day the card no longer applies, nil for the current card.
+	ValidUntil *time.Time `json:"validUntil,omitempty"`
+}
+
+type RateCardService struct {
+	rDB *RateCardDB
+}
+
+func NewRateCardService(rDB *RateCardDB) *RateCardService {
+	return &RateCardService{
+		rDB: rDB,
+	}
+}
+
+// PutRateCard stores a new card. It fails with ErrRateCardOverlap when another card of the
+// same machine and company is valid on one of its days.
+func (s *RateCardService) PutRateCard(rateCard RateCard) error {
+	return s.rDB.PutRateCard(rateCard)
+}
+
+func (s *RateCardService) UpdateRateCard(rateCard RateCard) error {
+	return s.rDB.UpdateRateCard(rateCard)
+}
+
+func (s *RateCardService) DeleteRateCardByID(rateCardID int) error {
+	return s.rDB.DeleteRateCardByID(rateCardID)
+}
+
+func (s *RateCardService) GetRateCardByID(rateCardID int) (RateCard, error) {
+	return s.rDB.GetRateCardByID(rateCardID)
+}
+
+// GetRateCards lists the cards of machineName, or of every machine if it is empty. With a
+// companyName only the cards that can apply to that company are returned, its own and the
+// defaults.
+func (s *RateCardService) GetRateCards(machineName, companyName string) ([]RateCard, error) {
+	return s.rDB.GetRateCards(machineName, companyName)
+}
@@ -0,0 +1,241 @@
+package ratecard
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+	"tzcnlr/auth"
+)
+
+var currencyPattern = regexp.MustCompile(`^[A-Z]{3}$`)
+
+type RateCardAPI struct {
+	s *RateCardService
+}
+
+func NewRateCardAPI(s *RateCardService) *RateCardAPI {
+	return &RateCardAPI{
+		s: s,
+	}
+}
+
+func (api *RateCardAPI) HandlePostRateCard(w http.ResponseWriter, r *http.Request) {
+	rateCard, ok := r.Context().Value("rateCard").(RateCard)
+	if !ok {
+		http.Error(w, "error during json decode", http.StatusInternalServerError)
+		return
+	}
+
+	err := api.s.PutRateCard(rateCard)
+	writeRateCardError(w, err)
+}
+
+func (api *RateCardAPI) HandleUpdateRateCard(w http.ResponseWriter, r *http.Request) {
+	rateCardID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "invalid rate card id", http.StatusBadRequest)
+		return
+	}
+
+	rateCard, ok := r.Context().Value("rateCard").(RateCard)
+	if !ok {
+		http.Error(w, "error during json decode", http.StatusInternalServerError)
+		return
+	}
+	rateCard.RateCardID = rateCardID
+
+	err = api.s.UpdateRateCard(rateCard)
+	writeRateCardError(w, err)
+}
+
+// writeRateCardError answers a failed insert or update, it writes nothing for a nil err.
+func writeRateCardError(w http.ResponseWriter, err error) {
+	switch {
+	case err == nil:
+	case errors.Is(err, ErrRateCardNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrUnknownReference):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, ErrRateCardOverlap):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (api *RateCardAPI) HandleDeleteRateCard(w http.ResponseWriter, r *http.Request) {
+	rateCardID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "invalid rate card id", http.StatusBadRequest)
+		return
+	}
+
+	err = api.s.DeleteRateCardByID(rateCardID)
+	if errors.Is(err, ErrRateCardNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (api *RateCardAPI) HandleGetRateCardByID(w http.ResponseWriter, r *http.Request) {
+	rateCardID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "invalid rate card id", http.StatusBadRequest)
+		return
+	}
+
+	rateCard, err := api.s.GetRateCardByID(rateCardID)
+	if errors.Is(err, ErrRateCardNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// company bound users see the defaults, but not the prices other companies get
+	if rateCard.CompanyName != "" {
+		if _, err = auth.ScopeCompanyName(r.Context(), rateCard.CompanyName); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+	}
+
+	jsonResponse, err := json.Marshal(rateCard)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
+
+func (api *RateCardAPI) HandleGetRateCards(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	companyName, err := auth.ScopeCompanyName(r.Context(), query.Get("companyName"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	result, err := api.s.GetRateCards(query.Get("machineName"), companyName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// dont return null
+	if result == nil {
+		result = []RateCard{}
+	}
+
+	jsonResponse, err := json.Marshal(result)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
+
+func (api *RateCardAPI) DecodeRateCardBodyHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := r.Context().Value("body").([]byte)
+		if !ok {
+			http.Error(w, "error accessing the body of the request", http.StatusInternalServerError)
+			return
+		}
+
+		if len(body) == 0 {
+			http.Error(w, "empty request body", http.StatusBadRequest)
+			return
+		}
+
+		rateCard, err := decodeRateCard(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err = validateRateCard(rateCard); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), "rateCard", rateCard)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// decodeRateCard decodes a card whose validity days are plain dates like 2024-03-01, as the
+// days of completed tasks are. Full timestamps are still taken for older clients.
+func decodeRateCard(body []byte) (RateCard, error) {
+	var data struct {
+		RateCard
+		ValidFrom  string  `json:"validFrom"`
+		ValidUntil *string `json:"validUntil"`
+	}
+	err := json.Unmarshal(body, &data)
+	if err != nil {
+		return data.RateCard, err
+	}
+
+	rateCard := data.RateCard
+	if data.ValidFrom != "" {
+		if rateCard.ValidFrom, err = parseDay(data.ValidFrom); err != nil {
+			return rateCard, fmt.Errorf("invalid validFrom: %w", err)
+		}
+	}
+	if data.ValidUntil != nil && *data.ValidUntil != "" {
+		validUntil, err := parseDay(*data.ValidUntil)
+		if err != nil {
+			return rateCard, fmt.Errorf("invalid validUntil: %w", err)
+		}
+		rateCard.ValidUntil = &validUntil
+	}
+	return rateCard, nil
+}
+
+func parseDay(value string) (time.Time, error) {
+	day, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		if day, err = time.Parse(time.RFC3339, value); err != nil {
+			return time.Time{}, errors.New("expected a date like 2006-01-02")
+		}
+	}
+	return day, nil
+}
+
+func validateRateCard(rateCard RateCard) error {
+	if rateCard.MachineName == "" {
+		return errors.New("machine name not provided in request body")
+	}
+	if rateCard.HourlyRate < 0 || rateCard.DailyRate < 0 {
+		return errors.New("rates can not be negative")
+	}
+	if !currencyPattern.MatchString(rateCard.Currency) {
+		return errors.New("currency must be a three letter ISO 4217 code like TRY")
+	}
+	if rateCard.ValidFrom.IsZero() {
+		return errors.New("validFrom not provided in request body")
+	}
+	if rateCard.ValidUntil != nil && !rateCard.ValidUntil.After(rateCard.ValidFrom) {
+		return errors.New("validUntil must be after validFrom")
+	}
+	return nil
+}
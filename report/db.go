@@ -0,0 +1,265 @@
+package report
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"time"
+)
+
+type ReportDB struct {
+	db *pgxpool.Pool
+}
+
+func NewReportDB(db *pgxpool.Pool) *ReportDB {
+	return &ReportDB{
+		db: db,
+	}
+}
+
+// utilizationRow is the booking of one machine in one period by one branch, CompanyName is
+// empty for a period without bookings.
+type utilizationRow struct {
+	MachineName      string
+	PeriodStart      time.Time
+	AvailableMinutes int64
+	CompanyName      string
+	BranchName       string
+	ServiceMinutes   int64
+	RentalMinutes    int64
+}
+
+// GetUtilization reads one row per machine, period and booking branch ordered by machine,
+// period, company and branch. Periods are laid out on the wall clock of filter.Location, so a
+// day with a DST change has 23 or 25 hours available. Archived machines are left out unless
+// they were booked in the range or filter.IncludeArchived is set.
+func (r *ReportDB) GetUtilization(filter UtilizationFilter) ([]utilizationRow, error) {
+	query := `
+	WITH periods AS (
+		SELECT p::date AS period_start,
+			GREATEST(p, $1::date::timestamp) AT TIME ZONE $4 AS range_start,
+			LEAST(p + ('1 ' || $3)::interval, ($2::date + 1)::timestamp) AT TIME ZONE $4 AS range_end
+		FROM generate_series(date_trunc($3, $1::date::timestamp), $2::date::timestamp, ('1 ' || $3)::interval) p
+	), bookings AS (
+		SELECT t.machine_id, p.period_start, c.company_name, b.branch_name, t.is_rental,
+			EXTRACT(EPOCH FROM LEAST(t.task_end, p.range_end) - GREATEST(t.task_start, p.range_start)) / 60 AS minutes
+		FROM completed_task_logs t
+		JOIN periods p ON tstzrange(t.task_start, t.task_end) && tstzrange(p.range_start, p.range_end)
+		JOIN company c ON c.company_id = t.company_id
+		JOIN branch b ON b.branch_id = t.branch_id
+		WHERE t.task_end > $1::date::timestamp AT TIME ZONE $4 AND t.task_start < ($2::date + 1)::timestamp AT TIME ZONE $4
+			AND ($5 = '' OR c.company_name = $5) AND ($6 = '' OR b.branch_name = $6)
+	)
+	SELECT m.machine_name, p.period_start, (EXTRACT(EPOCH FROM p.range_end - p.range_start) / 60)::bigint,
+		COALESCE(k.company_name, ''), COALESCE(k.branch_name, ''),
+		COALESCE(round(sum(k.minutes) FILTER (WHERE NOT k.is_rental)), 0)::bigint,
+		COALESCE(round(sum(k.minutes) FILTER (WHERE k.is_rental)), 0)::bigint
+	FROM machine m
+	CROSS JOIN periods p
+	LEFT JOIN bookings k ON k.machine_id = m.machine_id AND k.period_start = p.period_start
+	WHERE (cardinality($7::text[]) = 0 OR m.machine_name = ANY($7))
+		AND ($8 OR m.archived_at IS NULL OR EXISTS (SELECT 1 FROM bookings WHERE machine_id = m.machine_id))
+	GROUP BY m.machine_name, p.period_start, p.range_start, p.range_end, k.company_name, k.branch_name
+	ORDER BY m.machine_name, p.period_start, k.company_name, k.branch_name`
+
+	machineNames := filter.MachineNames
+	if machineNames == nil {
+		machineNames = []string{}
+	}
+
+	rows, err := r.db.Query(context.Background(), query,
+		filter.From,
+		filter.To,
+		filter.Period,
+		filter.Location.String(),
+		filter.CompanyName,
+		filter.BranchName,
+		machineNames,
+		filter.IncludeArchived,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+	var result []utilizationRow
+	for rows.Next() {
+		var row utilizationRow
+		err := rows.Scan(&row.MachineName, &row.PeriodStart, &row.AvailableMinutes, &row.CompanyName, &row.BranchName, &row.ServiceMinutes, &row.RentalMinutes)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, row)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// GetSummaries totals the tasks per company, or per branch with byBranch. Companies and
+// branches without tasks are included with zero totals, archived ones only with
+// filter.IncludeArchived.
+func (r *ReportDB) GetSummaries(filter SummaryFilter, byBranch bool) ([]Summary, error) {
+	// groupKey is the column the tasks are totalled by, groups the rows a summary is made for
+	groupKey := "t.company_id"
+	groups := `SELECT c.company_id AS group_id, c.company_name, '' AS branch_name
+		FROM company c
+		WHERE ($1 = '' OR c.company_name = $1) AND ($5 OR c.archived_at IS NULL)`
+	if byBranch {
+		groupKey = "t.branch_id"
+		groups = `SELECT b.branch_id AS group_id, c.company_name, b.branch_name
+		FROM branch b JOIN company c ON c.company_id = b.company_id
+		WHERE ($1 = '' OR c.company_name = $1) AND ($5 OR (b.archived_at IS NULL AND c.archived_at IS NULL))`
+	}
+
+	query := fmt.Sprintf(`
+	WITH groups AS (
+		%[2]s
+	), tasks AS (
+		SELECT %[1]s AS group_id, t.machine_id, t.is_rental, t.task_duration_in_minutes, t.task_start_date, t.task_end_date
+		FROM completed_task_logs t
+		WHERE %[1]s IN (SELECT group_id FROM groups)
+			AND ($2::date IS NULL OR t.task_start_date >= $2) AND ($3::date IS NULL OR t.task_start_date <= $3)
+	), totals AS (
+		SELECT group_id, count(*) AS task_count,
+			COALESCE(sum(task_duration_in_minutes) FILTER (WHERE NOT is_rental), 0) AS service_minutes,
+			COALESCE(sum(task_duration_in_minutes / 1440) FILTER (WHERE is_rental), 0) AS rental_days,
+			min(task_start_date) AS first_activity, max(task_end_date) AS last_activity
+		FROM tasks
+		GROUP BY group_id
+	), machines AS (
+		SELECT k.group_id, m.machine_name, count(*) AS task_count, sum(k.task_duration_in_minutes) AS minutes,
+			row_number() OVER (PARTITION BY k.group_id ORDER BY sum(k.task_duration_in_minutes) DESC, m.machine_name) AS rank
+		FROM tasks k JOIN machine m ON m.machine_id = k.machine_id
+		GROUP BY k.group_id, m.machine_name
+	)
+	SELECT g.company_name, g.branch_name, COALESCE(o.task_count, 0), COALESCE(o.service_minutes, 0), COALESCE(o.rental_days, 0),
+		o.first_activity, o.last_activity,
+		COALESCE((
+			SELECT json_agg(json_build_object('machineName', x.machine_name, 'taskCount', x.task_count, 'minutes', x.minutes) ORDER BY x.rank)
+			FROM machines x WHERE x.group_id = g.group_id AND x.rank <= $4
+		), '[]')
+	FROM groups g
+	LEFT JOIN totals o ON o.group_id = g.group_id
+	ORDER BY g.company_name, g.branch_name`, groupKey, groups)
+
+	var from, to *time.Time
+	if !filter.From.IsZero() {
+		from = &filter.From
+	}
+	if !filter.To.IsZero() {
+		to = &filter.To
+	}
+
+	rows, err := r.db.Query(context.Background(), query, filter.CompanyName, from, to, filter.TopMachines, filter.IncludeArchived)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+	var summaries []Summary
+	for rows.Next() {
+		var summary Summary
+		var serviceMinutes int64
+		err := rows.Scan(
+			&summary.CompanyName,
+			&summary.BranchName,
+			&summary.TaskCount,
+			&serviceMinutes,
+			&summary.RentalDays,
+			&summary.FirstActivityDate,
+			&summary.LastActivityDate,
+			&summary.TopMachines)
+		if err != nil {
+			return nil, err
+		}
+		summary.ServiceHours = float64(serviceMinutes) / 60
+		summaries = append(summaries, summary)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return summaries, nil
+}
+
+// timeSeriesRow is the work of one group in one bucket, the names not grouped by are empty.
+type timeSeriesRow struct {
+	Bucket         time.Time
+	CompanyName    string
+	BranchName     string
+	MachineName    string
+	TaskCount      int64
+	ServiceMinutes int64
+	RentalMinutes  int64
+}
+
+// timeSeriesGroups are the name columns selected for each way of grouping a time series.
+var timeSeriesGroups = map[string]string{
+	GroupByNone:    "''::text, ''::text, ''::text",
+	GroupByCompany: "c.company_name, ''::text, ''::text",
+	GroupByBranch:  "c.company_name, b.branch_name, ''::text",
+	GroupByMachine: "''::text, ''::text, m.machine_name",
+}
+
+// GetTimeSeries totals the tasks per group and bucket, ordered by group and bucket. The buckets
+// are cut with date_trunc on the wall clock of filter.Location.
+func (r *ReportDB) GetTimeSeries(filter TimeSeriesFilter) ([]timeSeriesRow, error) {
+	groupColumns, ok := timeSeriesGroups[filter.GroupBy]
+	if !ok {
+		return nil, fmt.Errorf("unknown grouping %q", filter.GroupBy)
+	}
+
+	query := fmt.Sprintf(`
+	SELECT %[1]s, date_trunc($3, t.task_start AT TIME ZONE $4)::date AS bucket, count(*),
+		COALESCE(sum(t.task_duration_in_minutes) FILTER (WHERE NOT t.is_rental), 0),
+		COALESCE(sum(t.task_duration_in_minutes) FILTER (WHERE t.is_rental), 0)
+	FROM completed_task_logs t
+	JOIN company c ON c.company_id = t.company_id
+	JOIN branch b ON b.branch_id = t.branch_id
+	JOIN machine m ON m.machine_id = t.machine_id
+	WHERE t.task_start >= $1::date::timestamp AT TIME ZONE $4 AND t.task_start < ($2::date + 1)::timestamp AT TIME ZONE $4
+		AND ($5 = '' OR c.company_name = $5) AND ($6 = '' OR b.branch_name = $6)
+		AND (cardinality($7::text[]) = 0 OR m.machine_name = ANY($7))
+	GROUP BY 1, 2, 3, bucket
+	ORDER BY 1, 2, 3, bucket`, groupColumns)
+
+	machineNames := filter.MachineNames
+	if machineNames == nil {
+		machineNames = []string{}
+	}
+
+	rows, err := r.db.Query(context.Background(), query,
+		filter.From,
+		filter.To,
+		filter.Period,
+		filter.Location.String(),
+		filter.CompanyName,
+		filter.BranchName,
+		machineNames,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+	var result []timeSeriesRow
+	for rows.Next() {
+		var row timeSeriesRow
+		err := rows.Scan(&row.CompanyName, &row.BranchName, &row.MachineName, &row.Bucket, &row.TaskCount, &row.ServiceMinutes, &row.RentalMinutes)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, row)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
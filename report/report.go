@@ -0,0 +1,272 @@
+package report
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	PeriodDay   = "day"
+	PeriodWeek  = "week"
+	PeriodMonth = "month"
+)
+
+// maxPeriods bounds the periods of one report, a report has a row for every machine and period.
+const maxPeriods = 1000
+
+var ErrTooManyPeriods = errors.New("the range spans too many periods, choose a longer period or a shorter range")
+
+// UtilizationFilter selects the machines and tasks of a utilization report over the days
+// From to To, both included, split into periods of Period in Location. CompanyName and
+// BranchName only narrow the counted tasks, machines without any are reported as idle.
+type UtilizationFilter struct {
+	From            time.Time
+	To              time.Time
+	Period          string
+	Location        *time.Location
+	MachineNames    []string
+	CompanyName     string
+	BranchName      string
+	IncludeArchived bool
+}
+
+// Utilization is the booking of one machine in one period. Periods are cut to the range of the
+// report, so the first and the last one can be shorter. The minutes of a task are counted in
+// every period it overlaps, by the part that falls into it.
+type Utilization struct {
+	MachineName      string                 `json:"machineName"`
+	PeriodStart      time.Time              `json:"periodStart"`
+	AvailableMinutes int64                  `json:"availableMinutes"`
+	BookedMinutes    int64                  `json:"bookedMinutes"`
+	ServiceMinutes   int64                  `json:"serviceMinutes"`
+	RentalMinutes    int64                  `json:"rentalMinutes"`
+	Utilization      float64                `json:"utilization"`
+	Breakdown        []UtilizationBreakdown `json:"breakdown"`
+}
+
+// UtilizationBreakdown is the part of a Utilization booked by one branch of a company.
+type UtilizationBreakdown struct {
+	CompanyName    string `json:"companyName"`
+	BranchName     string `json:"branchName"`
+	ServiceMinutes int64  `json:"serviceMinutes"`
+	RentalMinutes  int64  `json:"rentalMinutes"`
+}
+
+// SummaryFilter selects the companies or branches of a summary and the tasks it counts. The
+// zero From and To do not limit the tasks.
+type SummaryFilter struct {
+	CompanyName     string
+	From            time.Time
+	To              time.Time
+	TopMachines     int
+	IncludeArchived bool
+}
+
+// Summary totals the tasks of a company, or of one of its branches when BranchName is set.
+// The activity dates are nil when there are no tasks.
+type Summary struct {
+	CompanyName       string         `json:"companyName"`
+	BranchName        string         `json:"branchName,omitempty"`
+	TaskCount         int64          `json:"taskCount"`
+	ServiceHours      float64        `json:"serviceHours"`
+	RentalDays        int64          `json:"rentalDays"`
+	FirstActivityDate *time.Time     `json:"firstActivityDate"`
+	LastActivityDate  *time.Time     `json:"lastActivityDate"`
+	TopMachines       []MachineTotal `json:"topMachines"`
+}
+
+// MachineTotal is the use of one machine within a summary, Minutes counts service and rental.
+type MachineTotal struct {
+	MachineName string `json:"machineName"`
+	TaskCount   int64  `json:"taskCount"`
+	Minutes     int64  `json:"minutes"`
+}
+
+const (
+	GroupByNone    = ""
+	GroupByCompany = "company"
+	GroupByBranch  = "branch"
+	GroupByMachine = "machine"
+)
+
+// TimeSeriesFilter selects the tasks started on the days From to To, both included, on the wall
+// clock of Location, and how they are bucketed and grouped.
+type TimeSeriesFilter struct {
+	From         time.Time
+	To           time.Time
+	Period       string
+	Location     *time.Location
+	GroupBy      string
+	CompanyName  string
+	BranchName   string
+	MachineNames []string
+}
+
+// TimeSeries holds one Series per group, each with a value for every bucket in Buckets, zero
+// where the group had no tasks. A task is counted whole in the bucket it started in.
+type TimeSeries struct {
+	Period  string      `json:"period"`
+	GroupBy string      `json:"groupBy,omitempty"`
+	Buckets []time.Time `json:"buckets"`
+	Series  []Series    `json:"series"`
+}
+
+// Series is the work of one group over time, the names not grouped by are empty.
+type Series struct {
+	CompanyName    string  `json:"companyName,omitempty"`
+	BranchName     string  `json:"branchName,omitempty"`
+	MachineName    string  `json:"machineName,omitempty"`
+	TaskCount      []int64 `json:"taskCount"`
+	ServiceMinutes []int64 `json:"serviceMinutes"`
+	RentalMinutes  []int64 `json:"rentalMinutes"`
+}
+
+type ReportService struct {
+	rDB *ReportDB
+}
+
+func NewReportService(rDB *ReportDB) *ReportService {
+	return &ReportService{
+		rDB: rDB,
+	}
+}
+
+// GetUtilization returns the utilization of every machine of filter in every period, ordered by
+// machine and period.
+func (s *ReportService) GetUtilization(filter UtilizationFilter) ([]Utilization, error) {
+	if countPeriods(filter.From, filter.To, filter.Period) > maxPeriods {
+		return nil, ErrTooManyPeriods
+	}
+
+	rows, err := s.rDB.GetUtilization(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []Utilization
+	for _, row := range rows {
+		last := len(result) - 1
+		if last < 0 || result[last].MachineName != row.MachineName || !result[last].PeriodStart.Equal(row.PeriodStart) {
+			result = append(result, Utilization{
+				MachineName:      row.MachineName,
+				PeriodStart:      row.PeriodStart,
+				AvailableMinutes: row.AvailableMinutes,
+				Breakdown:        []UtilizationBreakdown{},
+			})
+			last++
+		}
+		// periods without tasks come as a single row without company
+		if row.CompanyName == "" {
+			continue
+		}
+
+		u := &result[last]
+		u.ServiceMinutes += row.ServiceMinutes
+		u.RentalMinutes += row.RentalMinutes
+		u.Breakdown = append(u.Breakdown, UtilizationBreakdown{
+			CompanyName:    row.CompanyName,
+			BranchName:     row.BranchName,
+			ServiceMinutes: row.ServiceMinutes,
+			RentalMinutes:  row.RentalMinutes,
+		})
+	}
+
+	for i := range result {
+		u := &result[i]
+		u.BookedMinutes = u.ServiceMinutes + u.RentalMinutes
+		if u.AvailableMinutes > 0 {
+			u.Utilization = float64(u.BookedMinutes) / float64(u.AvailableMinutes)
+		}
+	}
+	return result, nil
+}
+
+// GetCompanySummaries totals the tasks of every company, or of filter.CompanyName only.
+func (s *ReportService) GetCompanySummaries(filter SummaryFilter) ([]Summary, error) {
+	return s.rDB.GetSummaries(filter, false)
+}
+
+// GetBranchSummaries totals the tasks of every branch, or of the branches of filter.CompanyName.
+func (s *ReportService) GetBranchSummaries(filter SummaryFilter) ([]Summary, error) {
+	return s.rDB.GetSummaries(filter, true)
+}
+
+// GetTimeSeries buckets the tasks of filter by their start into periods of filter.Period.
+func (s *ReportService) GetTimeSeries(filter TimeSeriesFilter) (TimeSeries, error) {
+	series := TimeSeries{Period: filter.Period, GroupBy: filter.GroupBy, Buckets: []time.Time{}, Series: []Series{}}
+	if countPeriods(filter.From, filter.To, filter.Period) > maxPeriods {
+		return series, ErrTooManyPeriods
+	}
+
+	bucketIndex := make(map[string]int)
+	for bucket := truncateDate(filter.From, filter.Period); !bucket.After(filter.To); bucket = nextBucket(bucket, filter.Period) {
+		bucketIndex[bucket.Format(dateLayout)] = len(series.Buckets)
+		series.Buckets = append(series.Buckets, bucket)
+	}
+
+	rows, err := s.rDB.GetTimeSeries(filter)
+	if err != nil {
+		return series, err
+	}
+
+	for _, row := range rows {
+		last := len(series.Series) - 1
+		if last < 0 || series.Series[last].CompanyName != row.CompanyName || series.Series[last].BranchName != row.BranchName || series.Series[last].MachineName != row.MachineName {
+			series.Series = append(series.Series, Series{
+				CompanyName:    row.CompanyName,
+				BranchName:     row.BranchName,
+				MachineName:    row.MachineName,
+				TaskCount:      make([]int64, len(series.Buckets)),
+				ServiceMinutes: make([]int64, len(series.Buckets)),
+				RentalMinutes:  make([]int64, len(series.Buckets)),
+			})
+			last++
+		}
+
+		i, ok := bucketIndex[row.Bucket.Format(dateLayout)]
+		if !ok {
+			continue
+		}
+		series.Series[last].TaskCount[i] = row.TaskCount
+		series.Series[last].ServiceMinutes[i] = row.ServiceMinutes
+		series.Series[last].RentalMinutes[i] = row.RentalMinutes
+	}
+	return series, nil
+}
+
+// truncateDate is the start of the bucket of date, like date_trunc does it. Weeks start on
+// Monday.
+func truncateDate(date time.Time, period string) time.Time {
+	date = time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)
+	switch period {
+	case PeriodMonth:
+		return date.AddDate(0, 0, 1-date.Day())
+	case PeriodWeek:
+		return date.AddDate(0, 0, -(int(date.Weekday())+6)%7)
+	default:
+		return date
+	}
+}
+
+func nextBucket(bucket time.Time, period string) time.Time {
+	switch period {
+	case PeriodMonth:
+		return bucket.AddDate(0, 1, 0)
+	case PeriodWeek:
+		return bucket.AddDate(0, 0, 7)
+	default:
+		return bucket.AddDate(0, 0, 1)
+	}
+}
+
+// countPeriods is the number of periods of length period the days from to to touch.
+func countPeriods(from, to time.Time, period string) int {
+	switch period {
+	case PeriodMonth:
+		return (to.Year()-from.Year())*12 + int(to.Month()-from.Month()) + 1
+	case PeriodWeek:
+		return int(truncateDate(to, period).Sub(truncateDate(from, period)).Hours()/24)/7 + 1
+	default:
+		return int(to.Sub(from).Hours()/24) + 1
+	}
+}
@@ -0,0 +1,84 @@
+package report
+
+import (
+	"testing"
+	"time"
+)
+
+func date(t *testing.T, value string) time.Time {
+	t.Helper()
+	parsed, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed
+}
+
+func TestTruncateDate(t *testing.T) {
+	tests := []struct {
+		date   string
+		period string
+		want   string
+	}{
+		{"2024-03-13", PeriodDay, "2024-03-13"},
+		{"2024-03-13", PeriodWeek, "2024-03-11"},
+		{"2024-03-11", PeriodWeek, "2024-03-11"},
+		{"2024-03-17", PeriodWeek, "2024-03-11"},
+		{"2024-03-01", PeriodWeek, "2024-02-26"},
+		{"2024-01-03", PeriodWeek, "2024-01-01"},
+		{"2023-01-01", PeriodWeek, "2022-12-26"},
+		{"2024-03-13", PeriodMonth, "2024-03-01"},
+		{"2024-03-01", PeriodMonth, "2024-03-01"},
+		{"2024-02-29", PeriodMonth, "2024-02-01"},
+	}
+	for _, tt := range tests {
+		if got := truncateDate(date(t, tt.date), tt.period).Format("2006-01-02"); got != tt.want {
+			t.Errorf("truncateDate(%s, %s) is %s, want %s", tt.date, tt.period, got, tt.want)
+		}
+	}
+
+	// the clock and zone of the date do not move its bucket
+	istanbul, err := time.LoadLocation("Europe/Istanbul")
+	if err != nil {
+		t.Fatal(err)
+	}
+	late := time.Date(2024, 3, 31, 23, 30, 0, 0, istanbul)
+	if got := truncateDate(late, PeriodMonth); !got.Equal(date(t, "2024-03-01")) {
+		t.Errorf("truncateDate(%v, month) is %v, want 2024-03-01", late, got)
+	}
+}
+
+func TestCountPeriods(t *testing.T) {
+	tests := []struct {
+		from   string
+		to     string
+		period string
+		want   int
+	}{
+		{"2024-03-13", "2024-03-13", PeriodDay, 1},
+		{"2024-03-01", "2024-03-31", PeriodDay, 31},
+		{"2024-02-28", "2024-03-01", PeriodDay, 3},
+		{"2024-03-11", "2024-03-17", PeriodWeek, 1},
+		{"2024-03-17", "2024-03-18", PeriodWeek, 2},
+		{"2024-03-13", "2024-03-27", PeriodWeek, 3},
+		{"2024-03-01", "2024-03-31", PeriodWeek, 5},
+		{"2024-03-13", "2024-03-13", PeriodMonth, 1},
+		{"2024-03-31", "2024-04-01", PeriodMonth, 2},
+		{"2023-11-15", "2024-02-01", PeriodMonth, 4},
+	}
+	for _, tt := range tests {
+		from, to := date(t, tt.from), date(t, tt.to)
+		if got := countPeriods(from, to, tt.period); got != tt.want {
+			t.Errorf("countPeriods(%s, %s, %s) is %d, want %d", tt.from, tt.to, tt.period, got, tt.want)
+		}
+
+		// the count is the number of buckets a time series walks through
+		buckets := 0
+		for bucket := truncateDate(from, tt.period); !bucket.After(to); bucket = nextBucket(bucket, tt.period) {
+			buckets++
+		}
+		if buckets != tt.want {
+			t.Errorf("%s to %s has %d %s buckets, want %d", tt.from, tt.to, buckets, tt.period, tt.want)
+		}
+	}
+}
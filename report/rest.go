@@ -0,0 +1,285 @@
+package report
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"tzcnlr/auth"
+)
+
+const dateLayout = "2006-01-02"
+
+const (
+	defaultTopMachines = 3
+	maxTopMachines     = 20
+)
+
+type ReportAPI struct {
+	s        *ReportService
+	location *time.Location
+}
+
+// NewReportAPI lays out report periods in location unless a request names another time zone.
+func NewReportAPI(s *ReportService, location *time.Location) *ReportAPI {
+	return &ReportAPI{
+		s:        s,
+		location: location,
+	}
+}
+
+// HandleGetUtilization reports booked against available minutes per machine and period. Company
+// bound callers only see the bookings of their own company.
+func (api *ReportAPI) HandleGetUtilization(w http.ResponseWriter, r *http.Request) {
+	filter, err := api.parseUtilizationFilter(r)
+	if errors.Is(err, auth.ErrOutOfCompanyScope) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := api.s.GetUtilization(filter)
+	if errors.Is(err, ErrTooManyPeriods) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// dont return null
+	if result == nil {
+		result = []Utilization{}
+	}
+
+	jsonResponse, err := json.Marshal(result)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
+
+func (api *ReportAPI) parseUtilizationFilter(r *http.Request) (UtilizationFilter, error) {
+	query := r.URL.Query()
+	filter := UtilizationFilter{
+		BranchName:   query.Get("branchName"),
+		MachineNames: parseMachineNames(query),
+	}
+
+	companyName, err := auth.ScopeCompanyName(r.Context(), query.Get("companyName"))
+	if err != nil {
+		return filter, err
+	}
+	filter.CompanyName = companyName
+
+	filter.From, filter.To, filter.Period, filter.Location, err = parsePeriods(query, api.location)
+	if err != nil {
+		return filter, err
+	}
+
+	if value := query.Get("includeArchived"); value != "" {
+		if filter.IncludeArchived, err = strconv.ParseBool(value); err != nil {
+			return filter, errors.New("invalid includeArchived: " + err.Error())
+		}
+	}
+
+	return filter, nil
+}
+
+// parsePeriods reads the required from and to dates, the period defaulting to month and the
+// time zone defaulting to location.
+func parsePeriods(query url.Values, location *time.Location) (from, to time.Time, period string, loc *time.Location, err error) {
+	if from, err = time.Parse(dateLayout, query.Get("from")); err != nil {
+		return from, to, period, loc, errors.New("from must be a date like 2024-03-01")
+	}
+	if to, err = time.Parse(dateLayout, query.Get("to")); err != nil {
+		return from, to, period, loc, errors.New("to must be a date like 2024-03-31")
+	}
+	if to.Before(from) {
+		return from, to, period, loc, errors.New("to is before from")
+	}
+
+	period = PeriodMonth
+	if value := query.Get("period"); value != "" {
+		switch value {
+		case PeriodDay, PeriodWeek, PeriodMonth:
+			period = value
+		default:
+			return from, to, period, loc, errors.New("period must be day, week or month")
+		}
+	}
+
+	loc = location
+	if timeZone := query.Get("timeZone"); timeZone != "" {
+		if loc, err = time.LoadLocation(timeZone); err != nil {
+			return from, to, period, loc, errors.New("invalid time zone: " + err.Error())
+		}
+	}
+	return from, to, period, loc, nil
+}
+
+// parseMachineNames reads machineName, which may be repeated or hold a comma separated list.
+func parseMachineNames(query url.Values) []string {
+	var result []string
+	for _, machineNames := range query["machineName"] {
+		for _, machineName := range strings.Split(machineNames, ",") {
+			if machineName = strings.TrimSpace(machineName); machineName != "" {
+				result = append(result, machineName)
+			}
+		}
+	}
+	return result
+}
+
+// HandleGetTimeSeries answers with the tasks bucketed by day, week or month of their start,
+// optionally one series per company, branch or machine.
+func (api *ReportAPI) HandleGetTimeSeries(w http.ResponseWriter, r *http.Request) {
+	filter, err := api.parseTimeSeriesFilter(r)
+	if errors.Is(err, auth.ErrOutOfCompanyScope) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := api.s.GetTimeSeries(filter)
+	if errors.Is(err, ErrTooManyPeriods) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	jsonResponse, err := json.Marshal(result)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
+
+func (api *ReportAPI) parseTimeSeriesFilter(r *http.Request) (TimeSeriesFilter, error) {
+	query := r.URL.Query()
+	filter := TimeSeriesFilter{
+		BranchName:   query.Get("branchName"),
+		MachineNames: parseMachineNames(query),
+	}
+
+	companyName, err := auth.ScopeCompanyName(r.Context(), query.Get("companyName"))
+	if err != nil {
+		return filter, err
+	}
+	filter.CompanyName = companyName
+
+	filter.From, filter.To, filter.Period, filter.Location, err = parsePeriods(query, api.location)
+	if err != nil {
+		return filter, err
+	}
+
+	switch groupBy := query.Get("groupBy"); groupBy {
+	case GroupByNone, GroupByCompany, GroupByBranch, GroupByMachine:
+		filter.GroupBy = groupBy
+	default:
+		return filter, errors.New("groupBy must be company, branch or machine")
+	}
+
+	return filter, nil
+}
+
+// HandleGetCompanySummaries answers with the task totals of every company the caller can see.
+func (api *ReportAPI) HandleGetCompanySummaries(w http.ResponseWriter, r *http.Request) {
+	api.getSummaries(w, r, api.s.GetCompanySummaries)
+}
+
+// HandleGetBranchSummaries answers with the task totals of every branch the caller can see.
+func (api *ReportAPI) HandleGetBranchSummaries(w http.ResponseWriter, r *http.Request) {
+	api.getSummaries(w, r, api.s.GetBranchSummaries)
+}
+
+func (api *ReportAPI) getSummaries(w http.ResponseWriter, r *http.Request, get func(SummaryFilter) ([]Summary, error)) {
+	filter, err := parseSummaryFilter(r)
+	if errors.Is(err, auth.ErrOutOfCompanyScope) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := get(filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// dont return null
+	if result == nil {
+		result = []Summary{}
+	}
+
+	jsonResponse, err := json.Marshal(result)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
+
+func parseSummaryFilter(r *http.Request) (SummaryFilter, error) {
+	query := r.URL.Query()
+	filter := SummaryFilter{TopMachines: defaultTopMachines}
+
+	companyName, err := auth.ScopeCompanyName(r.Context(), query.Get("companyName"))
+	if err != nil {
+		return filter, err
+	}
+	filter.CompanyName = companyName
+
+	if from := query.Get("from"); from != "" {
+		if filter.From, err = time.Parse(dateLayout, from); err != nil {
+			return filter, errors.New("from must be a date like 2024-03-01")
+		}
+	}
+	if to := query.Get("to"); to != "" {
+		if filter.To, err = time.Parse(dateLayout, to); err != nil {
+			return filter, errors.New("to must be a date like 2024-03-31")
+		}
+	}
+
+	if top := query.Get("topMachines"); top != "" {
+		filter.TopMachines, err = strconv.Atoi(top)
+		if err != nil || filter.TopMachines < 0 || filter.TopMachines > maxTopMachines {
+			return filter, errors.New("topMachines must be a number from 0 to " + strconv.Itoa(maxTopMachines))
+		}
+	}
+
+	if value := query.Get("includeArchived"); value != "" {
+		if filter.IncludeArchived, err = strconv.ParseBool(value); err != nil {
+			return filter, errors.New("invalid includeArchived: " + err.Error())
+		}
+	}
+
+	return filter, nil
+}
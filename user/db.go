@@ -0,0 +1,263 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var ErrUserNotFound = errors.New("username does not exist")
+
+// userColumns are the columns scanUser reads from users u joined with company c.
+const userColumns = `u.user_id, u.username, u.role, COALESCE(c.company_name, ''), u.external_issuer, u.external_subject`
+
+type UserDB struct {
+	db *pgxpool.Pool
+}
+
+func NewUserDB(db *pgxpool.Pool) *UserDB {
+	return &UserDB{
+		db: db,
+	}
+}
+
+func (c *UserDB) PutUser(username, passwordHash, role, companyName string) error {
+	if err := c.checkCompanyExists(companyName); err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO users (username, password_hash, role, company_id)
+		VALUES ($1, $2, $3, (SELECT company_id FROM company WHERE company_name = $4))
+	`
+	_, err := c.db.Exec(context.Background(), query, username, passwordHash, role, companyName)
+	return err
+}
+
+// DeleteUserByName deletes the user after revoking its refresh tokens.
+func (c *UserDB) DeleteUserByName(username string) error {
+	ctx := context.Background()
+	return pgx.BeginFunc(ctx, c.db, func(tx pgx.Tx) error {
+		var userID int
+		err := tx.QueryRow(ctx, "SELECT user_id FROM users WHERE username = $1 FOR UPDATE", username).Scan(&userID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrUserNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		if err = revokeRefreshTokens(ctx, tx, userID); err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, "DELETE FROM users WHERE user_id = $1", userID)
+		return err
+	})
+}
+
+// UpdateUserByName updates the user and, when its password, role, company binding or identity
+// provider link changes, revokes its refresh tokens so the change is not outlived by sessions
+// started before it. A nil companyName keeps the company binding, an empty one removes it. A nil identity keeps
+// the link, a set one replaces it and drops the password, an empty one removes it.
+func (c *UserDB) UpdateUserByName(username, newUsername, passwordHash, role string, companyName *string, identity *ExternalIdentity) error {
+	if companyName != nil {
+		if err := c.checkCompanyExists(*companyName); err != nil {
+			return err
+		}
+	}
+
+	sql := `
+		UPDATE users SET
+			username = COALESCE(NULLIF($1, ''), username),
+			password_hash = CASE WHEN NULLIF($7::text, '') IS NOT NULL THEN NULL
+				ELSE COALESCE(NULLIF($2, ''), password_hash) END,
+			role = COALESCE(NULLIF($3, ''), role),
+			company_id = CASE WHEN $4::text IS NULL THEN company_id
+				ELSE (SELECT company_id FROM company WHERE company_name = $4) END,
+			external_issuer = CASE WHEN $6::text IS NULL THEN external_issuer ELSE NULLIF($6, '') END,
+			external_subject = CASE WHEN $6::text IS NULL THEN external_subject ELSE NULLIF($7, '') END
+		WHERE user_id = $5
+	`
+
+	var issuer, subject *string
+	if identity != nil {
+		issuer, subject = &identity.Issuer, &identity.Subject
+	}
+
+	ctx := context.Background()
+	return pgx.BeginFunc(ctx, c.db, func(tx pgx.Tx) error {
+		var userID int
+		var current User
+		query := `
+			SELECT u.user_id, u.role, COALESCE(c.company_name, '')
+			FROM users u LEFT JOIN company c ON u.company_id = c.company_id
+			WHERE u.username = $1
+			FOR UPDATE OF u
+		`
+		err := tx.QueryRow(ctx, query, username).Scan(&userID, &current.Role, &current.CompanyName)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrUserNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		// the binding is checked against the role and company the user ends up with
+		updated := current
+		if role != "" {
+			updated.Role = role
+		}
+		if companyName != nil {
+			updated.CompanyName = *companyName
+		}
+		if err = validateCompanyBinding(updated); err != nil {
+			return err
+		}
+
+		if _, err = tx.Exec(ctx, sql, newUsername, passwordHash, role, companyName, userID, issuer, subject); err != nil {
+			return err
+		}
+
+		// tokens issued before carry the old role and company, so they are revoked on a change
+		if passwordHash == "" && updated == current && identity == nil {
+			return nil
+		}
+		return revokeRefreshTokens(ctx, tx, userID)
+	})
+}
+
+// PutExternalUser creates a user without a password, linked to identity. A user created for the
+// same identity by a concurrent login is left as it is.
+func (c *UserDB) PutExternalUser(username, role string, identity ExternalIdentity) error {
+	query := `
+		INSERT INTO users (username, role, external_issuer, external_subject)
+		VALUES ($1, $2, $3, $4)
+	`
+	_, err := c.db.Exec(context.Background(), query, username, role, identity.Issuer, identity.Subject)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		if pgErr.ConstraintName == "users_external_identity_key" {
+			return nil
+		}
+		return ErrUsernameTaken
+	}
+	return err
+}
+
+func revokeRefreshTokens(ctx context.Context, tx pgx.Tx, userID int) error {
+	sql := `UPDATE refresh_tokens SET revoked_at = now() WHERE user_id = $1 AND revoked_at IS NULL`
+	_, err := tx.Exec(ctx, sql, userID)
+	return err
+}
+
+func (c *UserDB) GetUsers() ([]User, error) {
+	query := `
+		SELECT ` + userColumns + `
+		FROM users u LEFT JOIN company c ON u.company_id = c.company_id
+	`
+
+	var users []User
+	rows, err := c.db.Query(context.Background(), query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+// GetUserByName returns the user together with its stored password hash, empty for users
+// linked to an identity provider.
+func (c *UserDB) GetUserByName(username string) (User, string, error) {
+	query := `
+		SELECT ` + userColumns + `, COALESCE(u.password_hash, '')
+		FROM users u LEFT JOIN company c ON u.company_id = c.company_id
+		WHERE u.username = $1
+	`
+
+	var passwordHash string
+	user, err := scanUser(c.db.QueryRow(context.Background(), query, username), &passwordHash)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return user, "", ErrUserNotFound
+	}
+	return user, passwordHash, err
+}
+
+// GetUserByExternalIdentity returns the user linked to identity and whether it has a password.
+func (c *UserDB) GetUserByExternalIdentity(identity ExternalIdentity) (User, bool, error) {
+	query := `
+		SELECT ` + userColumns + `, u.password_hash IS NOT NULL
+		FROM users u LEFT JOIN company c ON u.company_id = c.company_id
+		WHERE u.external_issuer = $1 AND u.external_subject = $2
+	`
+
+	var hasPassword bool
+	user, err := scanUser(c.db.QueryRow(context.Background(), query, identity.Issuer, identity.Subject), &hasPassword)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return user, false, ErrUserNotFound
+	}
+	return user, hasPassword, err
+}
+
+func (c *UserDB) GetUserByID(userID int) (User, error) {
+	query := `
+		SELECT ` + userColumns + `
+		FROM users u LEFT JOIN company c ON u.company_id = c.company_id
+		WHERE u.user_id = $1
+	`
+
+	user, err := scanUser(c.db.QueryRow(context.Background(), query, userID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return user, ErrUserNotFound
+	}
+	return user, err
+}
+
+// scanUser reads the userColumns of row followed by dest.
+func scanUser(row pgx.Row, dest ...interface{}) (User, error) {
+	var user User
+	var issuer, subject *string
+	err := row.Scan(append([]interface{}{&user.UserID, &user.Username, &user.Role, &user.CompanyName, &issuer, &subject}, dest...)...)
+	if err == nil && issuer != nil && subject != nil {
+		user.ExternalIdentity = &ExternalIdentity{Issuer: *issuer, Subject: *subject}
+	}
+	return user, err
+}
+
+func (c *UserDB) CountUsers() (int, error) {
+	var count int
+	err := c.db.QueryRow(context.Background(), "SELECT count(*) FROM users").Scan(&count)
+	return count, err
+}
+
+// checkCompanyExists keeps a mistyped company name from silently leaving the user unbound.
+func (c *UserDB) checkCompanyExists(companyName string) error {
+	if companyName == "" {
+		return nil
+	}
+
+	var exists bool
+	query := "SELECT EXISTS (SELECT 1 FROM company WHERE company_name = $1)"
+	if err := c.db.QueryRow(context.Background(), query, companyName).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("%w: companyName does not exist", ErrInvalidUser)
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package user
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
+	"net/http"
+)
+
+type UserAPI struct {
+	s *UserService
+}
+
+func NewUserAPI(s *UserService) *UserAPI {
+	return &UserAPI{
+		s: s,
+	}
+}
+
+func (api *UserAPI) HandleUpdateUserByName(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	currentName := vars["username"]
+	if currentName == "" {
+		http.Error(w, "current username not provided in URL", http.StatusBadRequest)
+		return
+	}
+
+	body, ok := r.Context().Value("body").([]byte)
+	if !ok {
+		http.Error(w, "error accessing the body of the request", http.StatusInternalServerError)
+		return
+	}
+
+	if len(body) == 0 {
+		http.Error(w, "empty request body", http.StatusBadRequest)
+		return
+	}
+
+	// decoded apart from User, an absent companyName must keep the binding instead of clearing it
+	var update UserUpdate
+	if err := json.Unmarshal(body, &update); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if update.Username == "" {
+		http.Error(w, "username not provided in request body", http.StatusBadRequest)
+		return
+	}
+
+	err := api.s.UpdateUserByName(currentName, update)
+	if errors.Is(err, ErrUserNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, ErrInvalidUser) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error updating user: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (api *UserAPI) HandleDeleteUserByName(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	username := vars["username"]
+
+	if username == "" {
+		http.Error(w, "username not set", http.StatusBadRequest)
+		return
+	}
+
+	err := api.s.DeleteUserByName(username)
+	if errors.Is(err, ErrUserNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (api *UserAPI) HandlePostUser(w http.ResponseWriter, r *http.Request) {
+
+	user, ok := r.Context().Value("user").(User)
+	if !ok {
+		http.Error(w, "error during json decode", http.StatusInternalServerError)
+		return
+	}
+
+	if user.Password == "" {
+		http.Error(w, "password not provided in request body", http.StatusBadRequest)
+		return
+	}
+
+	err := api.s.PutUser(user)
+	if errors.Is(err, ErrInvalidUser) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (api *UserAPI) HandleGetUsers(w http.ResponseWriter, r *http.Request) {
+	result, err := api.s.GetUsers()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// dont return null
+	if result == nil {
+		result = []User{}
+	}
+
+	jsonResponse, err := json.Marshal(result)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
+
+func (api *UserAPI) DecodeUserBodyHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := r.Context().Value("body").([]byte)
+		if !ok {
+			http.Error(w, "error accessing the body of the request", http.StatusInternalServerError)
+			return
+		}
+
+		if len(body) == 0 {
+			http.Error(w, "empty request body", http.StatusBadRequest)
+			return
+		}
+
+		user, err := decodeUser(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if user.Username == "" {
+			http.Error(w, "username not provided in request body", http.StatusBadRequest)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), "user", user)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func decodeUser(body []byte) (User, error) {
+	var user User
+	err := json.Unmarshal(body, &user)
+	if err != nil {
+		return user, err
+	}
+	return user, nil
+}
@@ -0,0 +1,227 @@
+package user
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+)
+
+var ErrWrongCredentials = errors.New("wrong credentials")
+
+// ErrInvalidUser is wrapped by the errors of users and updates that are rejected as invalid.
+var ErrInvalidUser = errors.New("invalid user")
+
+var (
+	ErrUsernameTaken = errors.New("username is taken by an account not linked to this identity, an admin has to link it")
+	ErrLocalAccount  = errors.New("the account has a local password and can not be signed into through an identity provider")
+)
+
+const (
+	RoleAdmin      = "admin"
+	RoleDispatcher = "dispatcher"
+	RoleViewer     = "viewer"
+)
+
+type User struct {
+	UserID   int    `json:"id"`
+	Username string `json:"username"`
+	Password string `json:"password,omitempty"`
+	Role     string `json:"role"`
+	// CompanyName binds the user to a single company, empty means all companies
+	CompanyName string `json:"companyName,omitempty"`
+	// ExternalIdentity links the user to an identity provider, linked users have no password
+	ExternalIdentity *ExternalIdentity `json:"externalIdentity,omitempty"`
+}
+
+// ExternalIdentity names a user at an identity provider by the issuer and the sub claim of its
+// id tokens, which unlike the username never changes or gets reused.
+type ExternalIdentity struct {
+	Issuer  string `json:"issuer"`
+	Subject string `json:"subject"`
+}
+
+// UserUpdate is the body of a user update, an empty Password or Role keeps the current one. A
+// nil CompanyName keeps the company binding, an empty one removes it. A nil ExternalIdentity
+// keeps the link to an identity provider, a set one links the user and drops its password, an
+// empty one unlinks it and requires a new Password.
+type UserUpdate struct {
+	Username         string            `json:"username"`
+	Password         string            `json:"password"`
+	Role             string            `json:"role"`
+	CompanyName      *string           `json:"companyName"`
+	ExternalIdentity *ExternalIdentity `json:"externalIdentity"`
+}
+
+type UserService struct {
+	uDB *UserDB
+}
+
+func NewUserService(uDB *UserDB) *UserService {
+	return &UserService{
+		uDB: uDB,
+	}
+}
+
+func (s *UserService) PutUser(user User) error {
+	if user.Password == "" {
+		return fmt.Errorf("%w: password not set", ErrInvalidUser)
+	}
+	if user.ExternalIdentity != nil {
+		return fmt.Errorf("%w: users are linked to an identity provider by updating them", ErrInvalidUser)
+	}
+	if user.Role == "" {
+		user.Role = RoleViewer
+	}
+	if err := validateRole(user.Role); err != nil {
+		return err
+	}
+	if err := validateCompanyBinding(user); err != nil {
+		return err
+	}
+
+	passwordHash, err := hashPassword(user.Password)
+	if err != nil {
+		return err
+	}
+
+	return s.uDB.PutUser(strings.ToLower(user.Username), passwordHash, user.Role, user.CompanyName)
+}
+
+func (s *UserService) DeleteUserByName(username string) error {
+	err := s.uDB.DeleteUserByName(strings.ToLower(username))
+	return err
+}
+
+// UpdateUserByName renames the user and replaces whatever else update sets. The company binding
+// is checked against the role the user ends up with, see UserDB.UpdateUserByName.
+func (s *UserService) UpdateUserByName(username string, update UserUpdate) error {
+	if update.Role != "" {
+		if err := validateRole(update.Role); err != nil {
+			return err
+		}
+	}
+	if identity := update.ExternalIdentity; identity != nil {
+		if (identity.Issuer == "") != (identity.Subject == "") {
+			return fmt.Errorf("%w: externalIdentity needs both issuer and subject, or neither to unlink", ErrInvalidUser)
+		}
+		if identity.Subject != "" && update.Password != "" {
+			return fmt.Errorf("%w: users linked to an identity provider can not have a password", ErrInvalidUser)
+		}
+		if identity.Subject == "" && update.Password == "" {
+			return fmt.Errorf("%w: password not set for the unlinked user", ErrInvalidUser)
+		}
+	}
+
+	passwordHash := ""
+	if update.Password != "" {
+		var err error
+		passwordHash, err = hashPassword(update.Password)
+		if err != nil {
+			return err
+		}
+	}
+
+	return s.uDB.UpdateUserByName(strings.ToLower(username), strings.ToLower(update.Username), passwordHash, update.Role, update.CompanyName, update.ExternalIdentity)
+}
+
+func (s *UserService) GetUserByID(userID int) (User, error) {
+	result, err := s.uDB.GetUserByID(userID)
+	return result, err
+}
+
+func (s *UserService) GetUsers() ([]User, error) {
+	result, err := s.uDB.GetUsers()
+	return result, err
+}
+
+// Authenticate returns the user matching the given credentials or ErrWrongCredentials.
+func (s *UserService) Authenticate(username, password string) (User, error) {
+	user, passwordHash, err := s.uDB.GetUserByName(strings.ToLower(username))
+	if errors.Is(err, ErrUserNotFound) || (err == nil && passwordHash == "") {
+		// compare against a dummy hash anyway so unknown usernames and users without a password
+		// take as long as wrong passwords
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return User{}, ErrWrongCredentials
+	}
+	if err != nil {
+		return User{}, err
+	}
+
+	if err = bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(password)); err != nil {
+		return User{}, ErrWrongCredentials
+	}
+	return user, nil
+}
+
+// SyncExternalUser returns the user linked to identity. Without a linked user one named
+// username is created when create is set, an existing account of that name is never taken
+// over. A non empty role replaces the stored one.
+func (s *UserService) SyncExternalUser(identity ExternalIdentity, username, role string, create bool) (User, error) {
+	user, hasPassword, err := s.uDB.GetUserByExternalIdentity(identity)
+	if errors.Is(err, ErrUserNotFound) && create {
+		newRole := role
+		if newRole == "" {
+			newRole = RoleViewer
+		}
+		if err = validateRole(newRole); err != nil {
+			return User{}, err
+		}
+		if err = s.uDB.PutExternalUser(strings.ToLower(username), newRole, identity); err != nil {
+			return User{}, err
+		}
+		user, hasPassword, err = s.uDB.GetUserByExternalIdentity(identity)
+	}
+	if err != nil {
+		return User{}, err
+	}
+	if hasPassword {
+		return User{}, ErrLocalAccount
+	}
+
+	if role != "" && role != user.Role {
+		if err = s.UpdateUserByName(user.Username, UserUpdate{Username: user.Username, Role: role}); err != nil {
+			return User{}, err
+		}
+		user.Role = role
+	}
+	return user, nil
+}
+
+// CreateInitialUser creates the given user only when no user exists yet,
+// so a fresh deployment can still be logged into.
+func (s *UserService) CreateInitialUser(user User) error {
+	count, err := s.uDB.CountUsers()
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	return s.PutUser(user)
+}
+
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy-password"), bcrypt.DefaultCost)
+
+func validateRole(role string) error {
+	switch role {
+	case RoleAdmin, RoleDispatcher, RoleViewer:
+		return nil
+	}
+	return fmt.Errorf("%w: unknown role %q", ErrInvalidUser, role)
+}
+
+func validateCompanyBinding(user User) error {
+	if user.CompanyName != "" && user.Role == RoleAdmin {
+		return fmt.Errorf("%w: admins can not be bound to a company", ErrInvalidUser)
+	}
+	return nil
+}
+
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}